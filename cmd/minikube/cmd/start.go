@@ -482,6 +482,20 @@ func displayEnviron(env []string) {
 	}
 }
 
+// kubectlNamespace returns the namespace of the context written for kcs, which Update keeps unless --namespace is set
+func kubectlNamespace(kcs *kubeconfig.Settings) string {
+	ns := kcs.Namespace
+	if ns == "" {
+		if written, err := kubeconfig.GetSettings(kubeconfig.WritablePathFromEnv(), kcs.ClusterName); err == nil {
+			ns = written.Namespace
+		}
+	}
+	if ns == "" {
+		return "default"
+	}
+	return ns
+}
+
 func showKubectlInfo(kcs *kubeconfig.Settings, k8sVersion, rtime, machineName string) error {
 	if k8sVersion == constants.NoKubernetesVersion {
 		register.Reg.SetStep(register.Done)
@@ -508,10 +522,10 @@ func showKubectlInfo(kcs *kubeconfig.Settings, k8sVersion, rtime, machineName st
 	// To be shown at the end, regardless of exit path
 	defer func() {
 		register.Reg.SetStep(register.Done)
-		if kcs.KeepContext {
+		if kcs.KeepsContext() {
 			out.Step(style.Kubectl, "To connect to this cluster, use:  --context={{.name}}", out.V{"name": kcs.ClusterName})
 		} else {
-			out.Step(style.Ready, `Done! kubectl is now configured to use "{{.name}}" cluster and "{{.ns}}" namespace by default`, out.V{"name": machineName, "ns": kcs.Namespace})
+			out.Step(style.Ready, `Done! kubectl is now configured to use "{{.name}}" cluster and "{{.ns}}" namespace by default`, out.V{"name": machineName, "ns": kubectlNamespace(kcs)})
 		}
 	}()
 
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -410,7 +411,7 @@ func nodeStatus(api libmachine.API, cc config.ClusterConfig, n config.Node) (*St
 		klog.Errorf("forwarded endpoint: %v", err)
 		st.Kubeconfig = Misconfigured
 	} else {
-		err := kubeconfig.VerifyEndpoint(cc.Name, hostname, port)
+		err := kubeconfig.VerifyEndpoint(kubeconfig.WritablePathFromEnv(), cc.Name, "https://"+net.JoinHostPort(hostname, strconv.Itoa(port)))
 		if err != nil && st.Host != state.Starting.String() {
 			klog.Errorf("kubeconfig endpoint: %v", err)
 			st.Kubeconfig = Misconfigured
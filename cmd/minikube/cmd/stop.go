@@ -144,7 +144,7 @@ func stopProfile(profile string) int {
 	}
 
 	if !keepActive {
-		if err := kubeconfig.DeleteContext(profile, kubeconfig.PathFromEnv()); err != nil {
+		if err := kubeconfig.DeleteContext(profile, kubeconfig.WritablePathFromEnv()); err != nil {
 			exit.Error(reason.HostKubeconfigDeleteCtx, "delete ctx", err)
 		}
 	}
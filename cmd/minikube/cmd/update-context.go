@@ -37,7 +37,7 @@ var updateContextCmd = &cobra.Command{
 		co := mustload.Running(cname)
 		//	cluster extension metada for kubeconfig
 
-		updated, err := kubeconfig.UpdateEndpoint(cname, co.CP.Hostname, co.CP.Port, kubeconfig.PathFromEnv(), kubeconfig.NewExtension())
+		updated, err := kubeconfig.UpdateEndpoint(cname, co.CP.Hostname, co.CP.Port, kubeconfig.WritablePathFromEnv(), kubeconfig.NewExtension())
 		if err != nil {
 			exit.Error(reason.HostKubeconfigUpdate, "update config", err)
 		}
@@ -47,7 +47,7 @@ var updateContextCmd = &cobra.Command{
 			out.Styled(style.Meh, `No changes required for the "{{.context}}" context`, out.V{"context": cname})
 		}
 
-		if err := kubeconfig.SetCurrentContext(cname, kubeconfig.PathFromEnv()); err != nil {
+		if err := kubeconfig.SetCurrentContext(cname, kubeconfig.WritablePathFromEnv()); err != nil {
 			out.ErrT(style.Sad, `Error while setting kubectl current context:  {{.error}}`, out.V{"error": err})
 		} else {
 			out.Styled(style.Kubectl, `Current context is "{{.context}}"`, out.V{"context": cname})
@@ -100,6 +100,10 @@ const (
 	TestDiskAvailableEnv = "MINIKUBE_TEST_AVAILABLE_STORAGE"
 	// MinikubeRootlessEnv is used to force Rootless Docker/Podman driver
 	MinikubeRootlessEnv = "MINIKUBE_ROOTLESS"
+	// MinikubeKeepContextEnv is used to keep the current kubectl context when no explicit keep-context is given
+	MinikubeKeepContextEnv = "MINIKUBE_KEEP_CONTEXT"
+	// MinikubeServerOverrideEnv is used to write a different API server address, e.g. of a tunnel, into the kubeconfig
+	MinikubeServerOverrideEnv = "MINIKUBE_SERVER_OVERRIDE"
 
 	// scheduled stop constants
 
@@ -0,0 +1,82 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/juju/mutex"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+	"k8s.io/minikube/pkg/util/lock"
+	"k8s.io/minikube/pkg/version"
+)
+
+// auditEntry is a single line of the kubeconfig audit log
+type auditEntry struct {
+	Timestamp string `json:"timestamp"`
+	Operation string `json:"operation"`
+	Context   string `json:"context"`
+	File      string `json:"file"`
+	WrittenBy string `json:"written-by"`
+}
+
+// audit appends an entry for a successful kubeconfig mutation to auditLogPath.
+// Failures are only logged, the audit log must never fail the mutation itself.
+func audit(auditLogPath, operation, contextName, kubeConfigPath string) {
+	if auditLogPath == "" {
+		return
+	}
+	entry := auditEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Operation: operation,
+		Context:   contextName,
+		File:      kubeConfigPath,
+		WrittenBy: Managed + " " + version.GetVersion(),
+	}
+	if err := appendAuditLog(auditLogPath, entry); err != nil {
+		klog.Warningf("unable to write kubeconfig audit log %s: %v", auditLogPath, err)
+	}
+}
+
+// appendAuditLog writes entry as a single JSON line at the end of auditLogPath
+func appendAuditLog(auditLogPath string, entry auditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+	line = append(line, '\n')
+
+	spec := lock.PathMutexSpec(auditLogPath)
+	releaser, err := mutex.Acquire(spec)
+	if err != nil {
+		return errors.Wrapf(err, "failed to acquire lock for %s: %+v", auditLogPath, spec)
+	}
+	defer releaser.Release()
+
+	f, err := os.OpenFile(auditLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "open")
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return errors.Wrap(err, "write")
+	}
+	return f.Close()
+}
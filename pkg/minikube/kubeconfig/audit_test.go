@@ -0,0 +1,67 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	auditLog := filepath.Join(dir, "audit.log")
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+		AuditLogPath:         auditLog,
+	}
+	kcs.SetPath(filepath.Join(dir, "config"))
+
+	for i := 0; i < 2; i++ {
+		if err := Update(kcs); err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 audit lines but got %d:\n%s", len(lines), data)
+	}
+	for _, line := range lines {
+		var entry auditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("could not parse audit line %q: %v", line, err)
+		}
+		if entry.Operation != "update" || entry.Context != "minikube" || entry.File != kcs.filePath() || entry.WrittenBy == "" || entry.Timestamp == "" {
+			t.Errorf("unexpected audit entry: %+v", entry)
+		}
+	}
+
+	// an unwritable audit log doesn't fail the update
+	kcs.AuditLogPath = filepath.Join(auditLog, "nested")
+	if err := Update(kcs); err != nil {
+		t.Errorf("Got unexpected error: %v", err)
+	}
+}
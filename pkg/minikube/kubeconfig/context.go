@@ -17,7 +17,11 @@ limitations under the License.
 package kubeconfig
 
 import (
+	"sort"
+	"strings"
+
 	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/klog/v2"
 )
@@ -59,12 +63,21 @@ func SetCurrentContext(name string, configPath ...string) error {
 	return writeToFile(kcfg, fPath)
 }
 
-// DeleteContext deletes the specified machine's kubeconfig context
+// DeleteContext deletes the cluster, user and context of the specified machine under the lock Update uses,
+// and unsets the current-context if it pointed at the machine. Nothing is written if none of them exist.
+// Without configPath, the entries are deleted from WritablePathFromEnv, where Update writes them.
 func DeleteContext(machineName string, configPath ...string) error {
-	fPath := PathFromEnv()
+	fPath := WritablePathFromEnv()
 	if configPath != nil {
 		fPath = configPath[0]
 	}
+
+	releaser, err := acquireLock(fPath)
+	if err != nil {
+		return err
+	}
+	defer releaser.Release()
+
 	kcfg, err := readOrNew(fPath)
 	if err != nil {
 		return errors.Wrap(err, "Error getting kubeconfig status")
@@ -75,6 +88,14 @@ func DeleteContext(machineName string, configPath ...string) error {
 		return nil
 	}
 
+	_, hasCluster := kcfg.Clusters[machineName]
+	_, hasUser := kcfg.AuthInfos[machineName]
+	_, hasContext := kcfg.Contexts[machineName]
+	if !hasCluster && !hasUser && !hasContext && kcfg.CurrentContext != machineName {
+		klog.V(2).Infof("%q does not appear in %s", machineName, fPath)
+		return nil
+	}
+
 	delete(kcfg.Clusters, machineName)
 	delete(kcfg.AuthInfos, machineName)
 	delete(kcfg.Contexts, machineName)
@@ -88,3 +109,382 @@ func DeleteContext(machineName string, configPath ...string) error {
 	}
 	return nil
 }
+
+// DeriveNamespaceContext adds newContextName as a copy of baseContext that defaults to namespace.
+// The new context reuses the cluster and user entries of baseContext rather than duplicating them.
+func DeriveNamespaceContext(baseContext, namespace, newContextName, kubeConfigPath string) error {
+	if err := ValidateName(newContextName); err != nil {
+		return errors.Wrap(err, "invalid kubeconfig name")
+	}
+	releaser, err := acquireLock(kubeConfigPath)
+	if err != nil {
+		return err
+	}
+	defer releaser.Release()
+
+	kcfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return errors.Wrap(err, "Error getting kubeconfig status")
+	}
+
+	base, ok := kcfg.Contexts[baseContext]
+	if !ok {
+		return errors.Errorf("%q does not appear in %s", baseContext, kubeConfigPath)
+	}
+	if _, ok := kcfg.Contexts[newContextName]; ok {
+		return errors.Errorf("context %q already exists in %s", newContextName, kubeConfigPath)
+	}
+
+	context := base.DeepCopy()
+	context.Namespace = namespace
+	kcfg.Contexts[newContextName] = context
+
+	if err := writeToFile(kcfg, kubeConfigPath); err != nil {
+		return errors.Wrap(err, "writing kubeconfig")
+	}
+	return nil
+}
+
+// SetNamespaceForServer sets the namespace of every context whose cluster points at server, and returns the
+// number of contexts changed. Contexts of other servers are left untouched.
+func SetNamespaceForServer(server, namespace, kubeConfigPath string) (int, error) {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return 0, errors.Errorf("invalid namespace %q: %s", namespace, strings.Join(errs, ", "))
+	}
+
+	releaser, err := acquireLock(kubeConfigPath)
+	if err != nil {
+		return 0, err
+	}
+	defer releaser.Release()
+
+	kcfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "Error getting kubeconfig status")
+	}
+
+	server = strings.TrimSuffix(server, "/")
+	changed := 0
+	for name, context := range kcfg.Contexts {
+		cluster, ok := kcfg.Clusters[context.Cluster]
+		if !ok || strings.TrimSuffix(cluster.Server, "/") != server || context.Namespace == namespace {
+			continue
+		}
+		klog.Infof("switching context %q to namespace %q", name, namespace)
+		context.Namespace = namespace
+		changed++
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	if err := writeToFile(kcfg, kubeConfigPath); err != nil {
+		return 0, errors.Wrap(err, "writing kubeconfig")
+	}
+	return changed, nil
+}
+
+// RenameProfile renames the minikube contexts whose Profile extension is oldProfile, together with the
+// cluster and user entries they reference, following the <profile>[-suffix] naming convention.
+// It returns the number of contexts renamed.
+func RenameProfile(oldProfile, newProfile, kubeConfigPath string) (int, error) {
+	if err := ValidateName(newProfile); err != nil {
+		return 0, errors.Wrap(err, "invalid kubeconfig name")
+	}
+	releaser, err := acquireLock(kubeConfigPath)
+	if err != nil {
+		return 0, err
+	}
+	defer releaser.Release()
+
+	kcfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "Error getting kubeconfig status")
+	}
+
+	rename := func(name string) string {
+		if strings.HasPrefix(name, oldProfile) {
+			return newProfile + strings.TrimPrefix(name, oldProfile)
+		}
+		return name
+	}
+
+	contexts := map[string]string{}
+	clusters := map[string]string{}
+	users := map[string]string{}
+	for name, context := range kcfg.Contexts {
+		ext, err := decodeExtension(context.Extensions, contextExtensionKey)
+		if err != nil {
+			klog.Warningf("skipping context %q: %v", name, err)
+			continue
+		}
+		if !ext.managed() || ext.Profile != oldProfile {
+			continue
+		}
+		contexts[name] = rename(name)
+		clusters[context.Cluster] = rename(context.Cluster)
+		users[context.AuthInfo] = rename(context.AuthInfo)
+	}
+	if len(contexts) == 0 {
+		return 0, nil
+	}
+
+	for old, name := range contexts {
+		if _, renamed := contexts[name]; name != old && !renamed && kcfg.Contexts[name] != nil {
+			return 0, errors.Errorf("context %q already exists in %s", name, kubeConfigPath)
+		}
+	}
+	for old, name := range clusters {
+		if _, renamed := clusters[name]; name != old && !renamed && kcfg.Clusters[name] != nil {
+			return 0, errors.Errorf("cluster %q already exists in %s", name, kubeConfigPath)
+		}
+	}
+	for old, name := range users {
+		if _, renamed := users[name]; name != old && !renamed && kcfg.AuthInfos[name] != nil {
+			return 0, errors.Errorf("user %q already exists in %s", name, kubeConfigPath)
+		}
+	}
+
+	renamedClusters := map[string]*api.Cluster{}
+	for old, name := range clusters {
+		cluster, ok := kcfg.Clusters[old]
+		if !ok {
+			continue
+		}
+		if ext, err := decodeExtension(cluster.Extensions, clusterExtensionKey); err == nil && ext != nil {
+			ext.Profile = newProfile
+			cluster.Extensions[clusterExtensionKey] = ext
+		}
+		delete(kcfg.Clusters, old)
+		renamedClusters[name] = cluster
+	}
+	for name, cluster := range renamedClusters {
+		kcfg.Clusters[name] = cluster
+	}
+
+	renamedUsers := map[string]*api.AuthInfo{}
+	for old, name := range users {
+		user, ok := kcfg.AuthInfos[old]
+		if !ok {
+			continue
+		}
+		delete(kcfg.AuthInfos, old)
+		renamedUsers[name] = user
+	}
+	for name, user := range renamedUsers {
+		kcfg.AuthInfos[name] = user
+	}
+
+	// repoint every context, not only the renamed ones, so that shared entries stay resolvable
+	for _, context := range kcfg.Contexts {
+		if name, ok := clusters[context.Cluster]; ok {
+			context.Cluster = name
+		}
+		if name, ok := users[context.AuthInfo]; ok {
+			context.AuthInfo = name
+		}
+	}
+
+	renamedContexts := map[string]*api.Context{}
+	for old, name := range contexts {
+		context := kcfg.Contexts[old]
+		if ext, err := decodeExtension(context.Extensions, contextExtensionKey); err == nil && ext != nil {
+			ext.Profile = newProfile
+			context.Extensions[contextExtensionKey] = ext
+		}
+		delete(kcfg.Contexts, old)
+		renamedContexts[name] = context
+		if kcfg.CurrentContext == old {
+			kcfg.CurrentContext = name
+		}
+	}
+	for name, context := range renamedContexts {
+		kcfg.Contexts[name] = context
+	}
+
+	if err := writeToFile(kcfg, kubeConfigPath); err != nil {
+		return 0, errors.Wrap(err, "writing kubeconfig")
+	}
+	return len(contexts), nil
+}
+
+// RenameContext renames the cluster, user and context oldName to newName under the lock Update uses,
+// repointing every context that referenced them and the current-context. It refuses to merge into an
+// existing newName entry.
+func RenameContext(kubeConfigFile, oldName, newName string) error {
+	if err := ValidateName(newName); err != nil {
+		return errors.Wrap(err, "invalid kubeconfig name")
+	}
+	releaser, err := acquireLock(kubeConfigFile)
+	if err != nil {
+		return err
+	}
+	defer releaser.Release()
+
+	kcfg, err := readOrNew(kubeConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "Error getting kubeconfig status")
+	}
+
+	context, ok := kcfg.Contexts[oldName]
+	if !ok {
+		return errors.Errorf("%q does not appear in %s", oldName, kubeConfigFile)
+	}
+	if oldName == newName {
+		return nil
+	}
+	if _, ok := kcfg.Contexts[newName]; ok {
+		return errors.Errorf("context %q already exists in %s", newName, kubeConfigFile)
+	}
+	if _, ok := kcfg.Clusters[newName]; ok {
+		return errors.Errorf("cluster %q already exists in %s", newName, kubeConfigFile)
+	}
+	if _, ok := kcfg.AuthInfos[newName]; ok {
+		return errors.Errorf("user %q already exists in %s", newName, kubeConfigFile)
+	}
+
+	delete(kcfg.Contexts, oldName)
+	kcfg.Contexts[newName] = context
+	if cluster, ok := kcfg.Clusters[oldName]; ok {
+		delete(kcfg.Clusters, oldName)
+		kcfg.Clusters[newName] = cluster
+	}
+	if user, ok := kcfg.AuthInfos[oldName]; ok {
+		delete(kcfg.AuthInfos, oldName)
+		kcfg.AuthInfos[newName] = user
+	}
+	for _, c := range kcfg.Contexts {
+		if c.Cluster == oldName {
+			c.Cluster = newName
+		}
+		if c.AuthInfo == oldName {
+			c.AuthInfo = newName
+		}
+	}
+	if kcfg.CurrentContext == oldName {
+		kcfg.CurrentContext = newName
+	}
+
+	if err := writeToFile(kcfg, kubeConfigFile); err != nil {
+		return errors.Wrap(err, "writing kubeconfig")
+	}
+	return nil
+}
+
+// MakeInsecure drops the CA of the cluster behind contextName and disables TLS verification for it.
+// As this turns off server verification it refuses to run unless confirm is true.
+func MakeInsecure(contextName, kubeConfigPath string, confirm bool) error {
+	if !confirm {
+		return errors.Errorf("refusing to disable TLS verification for %q without confirmation", contextName)
+	}
+
+	releaser, err := acquireLock(kubeConfigPath)
+	if err != nil {
+		return err
+	}
+	defer releaser.Release()
+
+	kcfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return errors.Wrap(err, "Error getting kubeconfig status")
+	}
+	context, ok := kcfg.Contexts[contextName]
+	if !ok {
+		return errors.Errorf("%q does not appear in %s", contextName, kubeConfigPath)
+	}
+	cluster, ok := kcfg.Clusters[context.Cluster]
+	if !ok {
+		return errors.Errorf("cluster %q of context %q does not appear in %s", context.Cluster, contextName, kubeConfigPath)
+	}
+
+	klog.Warningf("!!! disabling TLS verification for cluster %q: connections to %s will no longer be verified !!!", context.Cluster, cluster.Server)
+	cluster.CertificateAuthority = ""
+	cluster.CertificateAuthorityData = nil
+	cluster.InsecureSkipTLSVerify = true
+
+	if err := writeToFile(kcfg, kubeConfigPath); err != nil {
+		return errors.Wrap(err, "writing kubeconfig")
+	}
+	return nil
+}
+
+// SanitizeCurrentContexts clears the current-context of every KUBECONFIG file that points at a
+// context not defined in that same file. It returns the number of files fixed.
+func SanitizeCurrentContexts() (int, error) {
+	fixed := 0
+	for _, fPath := range pathsFromEnv() {
+		ok, err := sanitizeCurrentContext(fPath)
+		if err != nil {
+			return fixed, err
+		}
+		if ok {
+			fixed++
+		}
+	}
+	return fixed, nil
+}
+
+// sanitizeCurrentContext clears a dangling current-context of fPath and reports whether it had to
+func sanitizeCurrentContext(fPath string) (bool, error) {
+	releaser, err := acquireLock(fPath)
+	if err != nil {
+		return false, err
+	}
+	defer releaser.Release()
+
+	kcfg, err := readOrNew(fPath)
+	if err != nil {
+		return false, errors.Wrap(err, "Error getting kubeconfig status")
+	}
+	if kcfg.CurrentContext == "" {
+		return false, nil
+	}
+	if _, ok := kcfg.Contexts[kcfg.CurrentContext]; ok {
+		return false, nil
+	}
+
+	klog.Infof("clearing current-context %q of %s: no such context", kcfg.CurrentContext, fPath)
+	kcfg.CurrentContext = ""
+	if err := writeToFile(kcfg, fPath); err != nil {
+		return false, errors.Wrap(err, "writing kubeconfig")
+	}
+	return true, nil
+}
+
+// IsMinikubeContext returns whether contextName was written by Managed
+func IsMinikubeContext(contextName, kubeConfigPath string) (bool, error) {
+	kcfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return false, errors.Wrap(err, "Error getting kubeconfig status")
+	}
+	context, ok := kcfg.Contexts[contextName]
+	if !ok {
+		return false, errors.Errorf("%q does not appear in %s", contextName, kubeConfigPath)
+	}
+	ext, err := decodeExtension(context.Extensions, contextExtensionKey)
+	if err != nil {
+		return false, err
+	}
+	return ext.managed(), nil
+}
+
+// ListMinikubeContexts returns the sorted names of the contexts written by Managed
+func ListMinikubeContexts(kubeConfigPath string) ([]string, error) {
+	kcfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting kubeconfig status")
+	}
+	names := []string{}
+	for name, context := range kcfg.Contexts {
+		ext, err := decodeExtension(context.Extensions, contextExtensionKey)
+		if err != nil {
+			klog.Warningf("skipping context %q: %v", name, err)
+			continue
+		}
+		if ext.managed() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
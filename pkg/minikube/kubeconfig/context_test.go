@@ -19,7 +19,10 @@ package kubeconfig
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"k8s.io/minikube/pkg/minikube/constants"
 )
 
 func TestDeleteContext(t *testing.T) {
@@ -48,8 +51,34 @@ func TestDeleteContext(t *testing.T) {
 	}
 }
 
+func TestDeleteContextMissing(t *testing.T) {
+	fn := tempFile(t, kubeConfig192)
+	defer os.Remove(fn)
+	if err := DeleteContext("other", fn); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(kubeConfig192) {
+		t.Errorf("Expected file to be left untouched, got:\n%s", got)
+	}
+
+	if err := DeleteContext("minikube", fn); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := readOrNew(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CurrentContext != "" || len(cfg.Contexts) != 0 {
+		t.Errorf("Expected minikube and the current-context to be removed, got %+v", cfg)
+	}
+}
+
 func TestSetCurrentContext(t *testing.T) {
-	f, err := os.CreateTemp("/tmp", "kubeconfig")
+	f, err := os.CreateTemp(t.TempDir(), "kubeconfig")
 	if err != nil {
 		t.Fatalf("Error not expected but got %v", err)
 	}
@@ -86,7 +115,15 @@ func TestSetCurrentContext(t *testing.T) {
 }
 
 func TestUnsetCurrentContext(t *testing.T) {
-	fn := filepath.Join("testdata", "kubeconfig", "config1")
+	// work on a copy, writing leaves a backup next to the file
+	data, err := os.ReadFile(filepath.Join("testdata", "kubeconfig", "config1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(t.TempDir(), "config1")
+	if err := os.WriteFile(fn, data, 0600); err != nil {
+		t.Fatal(err)
+	}
 	contextName := "minikube"
 
 	cfg, err := readOrNew(fn)
@@ -102,12 +139,6 @@ func TestUnsetCurrentContext(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error not expected but got %v", err)
 	}
-	defer func() {
-		err := SetCurrentContext(contextName, fn)
-		if err != nil {
-			t.Fatalf("Error not expected but got %v", err)
-		}
-	}()
 
 	cfg, err = readOrNew(fn)
 	if err != nil {
@@ -146,3 +177,348 @@ func TestUnsetCurrentContextOnlyChangesIfProfileIsTheCurrentContext(t *testing.T
 		t.Errorf("Expected context name %s but got %s", contextName, cfg.CurrentContext)
 	}
 }
+
+func TestDeriveNamespaceContext(t *testing.T) {
+	fn := tempFile(t, kubeConfigWithoutHTTPS)
+	defer os.Remove(fn)
+
+	if err := DeriveNamespaceContext("la-croix", "kube-system", "la-croix-kube-system", fn); err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+
+	cfg, err := readOrNew(fn)
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	if len(cfg.Clusters) != 1 || len(cfg.AuthInfos) != 1 {
+		t.Errorf("Expected cluster and user entries to be reused, got %d clusters and %d users", len(cfg.Clusters), len(cfg.AuthInfos))
+	}
+	context, ok := cfg.Contexts["la-croix-kube-system"]
+	if !ok {
+		t.Fatalf("Expected derived context to be written")
+	}
+	if context.Namespace != "kube-system" || context.Cluster != "la-croix" || context.AuthInfo != "la-croix" {
+		t.Errorf("Unexpected derived context: %+v", context)
+	}
+	if cfg.Contexts["la-croix"].Namespace != "" {
+		t.Errorf("Base context namespace was changed to %q", cfg.Contexts["la-croix"].Namespace)
+	}
+
+	if err := DeriveNamespaceContext("missing", "kube-system", "missing-kube-system", fn); err == nil {
+		t.Errorf("Expected error for missing base context but got none")
+	}
+	if err := DeriveNamespaceContext("la-croix", "default", "la-croix-kube-system", fn); err == nil {
+		t.Errorf("Expected error for existing context but got none")
+	}
+	if err := DeriveNamespaceContext("la-croix", "default", "la-croix kube-system", fn); err == nil {
+		t.Errorf("Expected error for invalid context name but got none")
+	}
+}
+
+var kubeConfigProfiles = []byte(`
+apiVersion: v1
+clusters:
+- cluster:
+    extensions:
+    - extension:
+        provider: minikube.sigs.k8s.io
+        profile: p1
+      name: cluster_info
+    server: https://192.168.10.100:8443
+  name: p1
+- cluster:
+    server: https://192.168.10.101:8443
+  name: p2
+contexts:
+- context:
+    cluster: p1
+    extensions:
+    - extension:
+        provider: minikube.sigs.k8s.io
+        profile: p1
+      name: context_info
+    user: p1
+  name: p1
+- context:
+    cluster: p1
+    extensions:
+    - extension:
+        provider: minikube.sigs.k8s.io
+        profile: p1
+      name: context_info
+    namespace: kube-system
+    user: p1
+  name: p1-kube-system
+- context:
+    cluster: p2
+    user: p2
+  name: p2
+current-context: p1
+kind: Config
+preferences: {}
+users:
+- name: p1
+  user:
+    client-certificate: /home/la-croix/.minikube/profiles/p1/client.crt
+    client-key: /home/la-croix/.minikube/profiles/p1/client.key
+- name: p2
+  user:
+    client-certificate: /home/la-croix/.minikube/profiles/p2/client.crt
+    client-key: /home/la-croix/.minikube/profiles/p2/client.key
+`)
+
+func TestSetNamespaceForServer(t *testing.T) {
+	fn := tempFile(t, kubeConfigWithoutHTTPSUpdated)
+	defer os.Remove(fn)
+	server := "https://192.168.10.100:8080"
+
+	if _, err := SetNamespaceForServer(server, "Not_A_Namespace", fn); err == nil {
+		t.Errorf("Expected error for invalid namespace but got none")
+	}
+
+	n, err := SetNamespaceForServer(server, "team-a", fn)
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 context changed but got %d", n)
+	}
+
+	cfg, err := readOrNew(fn)
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	if got := cfg.Contexts["minikube"].Namespace; got != "team-a" {
+		t.Errorf("Expected namespace team-a but got %q", got)
+	}
+	if got := cfg.Contexts["la-croix"].Namespace; got != "" {
+		t.Errorf("Context of another server was changed to namespace %q", got)
+	}
+
+	if n, err := SetNamespaceForServer(server, "team-a", fn); err != nil || n != 0 {
+		t.Errorf("Expected no changes on second call but got %d, %v", n, err)
+	}
+}
+
+func TestRenameProfile(t *testing.T) {
+	fn := tempFile(t, kubeConfigProfiles)
+	defer os.Remove(fn)
+
+	if _, err := RenameProfile("p1", "p2", fn); err == nil {
+		t.Errorf("Expected error renaming onto an existing profile but got none")
+	}
+	if _, err := RenameProfile("p1", "-dev", fn); err == nil {
+		t.Errorf("Expected error renaming to an invalid profile name but got none")
+	}
+
+	n, err := RenameProfile("p1", "dev", fn)
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 contexts renamed but got %d", n)
+	}
+
+	cfg, err := readOrNew(fn)
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	if cfg.CurrentContext != "dev" {
+		t.Errorf("Expected current context dev but got %q", cfg.CurrentContext)
+	}
+	for _, name := range []string{"dev", "dev-kube-system"} {
+		context, ok := cfg.Contexts[name]
+		if !ok {
+			t.Fatalf("Expected context %q to exist", name)
+		}
+		if context.Cluster != "dev" || context.AuthInfo != "dev" {
+			t.Errorf("Context %q was not repointed: %+v", name, context)
+		}
+		ext, err := decodeExtension(context.Extensions, contextExtensionKey)
+		if err != nil || ext == nil || ext.Profile != "dev" {
+			t.Errorf("Context %q extension profile was not updated: %+v, %v", name, ext, err)
+		}
+	}
+	if _, ok := cfg.Clusters["dev"]; !ok {
+		t.Errorf("Expected cluster dev to exist")
+	}
+	if _, ok := cfg.AuthInfos["dev"]; !ok {
+		t.Errorf("Expected user dev to exist")
+	}
+	if _, ok := cfg.Contexts["p2"]; !ok {
+		t.Errorf("Unrelated context p2 was removed")
+	}
+
+	n, err = RenameProfile("missing", "other", fn)
+	if err != nil || n != 0 {
+		t.Errorf("Expected no-op for unknown profile but got %d, %v", n, err)
+	}
+}
+
+func TestRenameContext(t *testing.T) {
+	fn := tempFile(t, kubeConfigProfiles)
+	defer os.Remove(fn)
+
+	if err := RenameContext(fn, "p1", "p2"); err == nil {
+		t.Errorf("Expected error renaming onto an existing context but got none")
+	}
+	if err := RenameContext(fn, "missing", "other"); err == nil {
+		t.Errorf("Expected error renaming a missing context but got none")
+	}
+	if err := RenameContext(fn, "p1", "dev/p1"); err == nil {
+		t.Errorf("Expected error renaming to an invalid context name but got none")
+	}
+
+	if err := RenameContext(fn, "p1", "dev"); err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+
+	cfg, err := readOrNew(fn)
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	if cfg.CurrentContext != "dev" {
+		t.Errorf("Expected current context dev but got %q", cfg.CurrentContext)
+	}
+	if _, ok := cfg.Contexts["p1"]; ok {
+		t.Errorf("Expected context p1 to be renamed")
+	}
+	for _, name := range []string{"dev", "p1-kube-system"} {
+		context, ok := cfg.Contexts[name]
+		if !ok {
+			t.Fatalf("Expected context %q to exist", name)
+		}
+		if context.Cluster != "dev" || context.AuthInfo != "dev" {
+			t.Errorf("Context %q was not repointed: %+v", name, context)
+		}
+	}
+	if cfg.Contexts["p1-kube-system"].Namespace != "kube-system" {
+		t.Errorf("Expected the namespace of p1-kube-system to be kept")
+	}
+	if _, ok := cfg.Clusters["dev"]; !ok {
+		t.Errorf("Expected cluster dev to exist")
+	}
+	if _, ok := cfg.AuthInfos["dev"]; !ok {
+		t.Errorf("Expected user dev to exist")
+	}
+	if _, ok := cfg.Contexts["p2"]; !ok {
+		t.Errorf("Unrelated context p2 was removed")
+	}
+}
+
+func TestManaged(t *testing.T) {
+	fn := tempFile(t, kubeConfigProfiles)
+	defer os.Remove(fn)
+
+	got, err := ListMinikubeContexts(fn)
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	if strings.Join(got, ",") != "p1,p1-kube-system" {
+		t.Errorf("Expected contexts p1,p1-kube-system but got %v", got)
+	}
+	if ok, err := IsMinikubeContext("p2", fn); err != nil || ok {
+		t.Errorf("Expected p2 not to be managed but got %t, %v", ok, err)
+	}
+
+	defer func(managed string) { Managed = managed }(Managed)
+	Managed = "fork.example.com"
+
+	if ok, err := IsMinikubeContext("p1", fn); err != nil || ok {
+		t.Errorf("Expected p1 not to be managed by the fork but got %t, %v", ok, err)
+	}
+	if got, err := ListMinikubeContexts(fn); err != nil || len(got) != 0 {
+		t.Errorf("Expected no contexts managed by the fork but got %v, %v", got, err)
+	}
+	if n, err := RenameProfile("p1", "dev", fn); err != nil || n != 0 {
+		t.Errorf("Expected contexts not managed by the fork to be left alone but got %d, %v", n, err)
+	}
+	if got, err := NeedsMigration(fn, "v1.28.0"); err != nil || len(got) != 0 {
+		t.Errorf("Expected no contexts managed by the fork to migrate but got %v, %v", got, err)
+	}
+
+	kcs := &Settings{
+		ClusterName:          "fork",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+	}
+	kcs.SetPath(fn)
+	if err := Update(kcs); err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	if ok, err := IsMinikubeContext("fork", fn); err != nil || !ok {
+		t.Errorf("Expected fork to be managed by the fork but got %t, %v", ok, err)
+	}
+	if got, err := ListMinikubeContexts(fn); err != nil || strings.Join(got, ",") != "fork" {
+		t.Errorf("Expected only the fork context but got %v, %v", got, err)
+	}
+}
+
+func TestMakeInsecure(t *testing.T) {
+	fn := tempFile(t, kubeConfigWithoutHTTPS)
+	defer os.Remove(fn)
+
+	if err := MakeInsecure("la-croix", fn, false); err == nil {
+		t.Errorf("Expected error without confirmation but got none")
+	}
+	cfg, err := readOrNew(fn)
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	if cfg.Clusters["la-croix"].InsecureSkipTLSVerify {
+		t.Fatalf("Cluster was made insecure without confirmation")
+	}
+
+	if err := MakeInsecure("la-croix", fn, true); err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	cfg, err = readOrNew(fn)
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	cluster := cfg.Clusters["la-croix"]
+	if !cluster.InsecureSkipTLSVerify || cluster.CertificateAuthority != "" || len(cluster.CertificateAuthorityData) != 0 {
+		t.Errorf("Expected CA to be cleared and verification skipped, got %+v", cluster)
+	}
+
+	if err := MakeInsecure("missing", fn, true); err == nil {
+		t.Errorf("Expected error for missing context but got none")
+	}
+}
+
+func TestSanitizeCurrentContexts(t *testing.T) {
+	valid := tempFile(t, kubeConfigWithoutHTTPS)
+	defer os.Remove(valid)
+	// only defines the "la-croix" context, current-context is pointed at "minikube" below
+	dangling := tempFile(t, kubeConfigMissingContext)
+	defer os.Remove(dangling)
+	unset := tempFile(t, kubeConfigNoClusters)
+	defer os.Remove(unset)
+	cfg, err := readOrNew(dangling)
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	cfg.CurrentContext = "minikube"
+	if err := writeToFile(cfg, dangling); err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	t.Setenv(constants.KubeconfigEnvVar, strings.Join([]string{valid, dangling, unset}, string(filepath.ListSeparator)))
+
+	n, err := SanitizeCurrentContexts()
+	if err != nil {
+		t.Fatalf("Error not expected but got %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 file fixed but got %d", n)
+	}
+
+	for fn, want := range map[string]string{valid: "la-croix", dangling: "", unset: ""} {
+		cfg, err := readOrNew(fn)
+		if err != nil {
+			t.Fatalf("Error not expected but got %v", err)
+		}
+		if cfg.CurrentContext != want {
+			t.Errorf("Expected current context %q in %s but got %q", want, fn, cfg.CurrentContext)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// definitions records, for an entry name, every KUBECONFIG file defining it in precedence order
+type definitions map[string][]string
+
+// describe returns where name is taken from, and which later files are ignored for it
+func (d definitions) describe(kind, name string) string {
+	files := d[name]
+	if len(files) == 0 {
+		return fmt.Sprintf("%s %q: not defined in any file", kind, name)
+	}
+	desc := fmt.Sprintf("%s %q: from %s", kind, name, files[0])
+	if len(files) > 1 {
+		desc += fmt.Sprintf(" (shadows %s)", strings.Join(files[1:], ", "))
+	}
+	return desc
+}
+
+// ExplainContext returns a human readable description of how kubectl resolves contextName across the
+// KUBECONFIG files: for the context, its cluster and its user, the first file defining it wins.
+// If contextName is empty, the current-context is explained instead.
+func ExplainContext(contextName string) (string, error) {
+	paths := pathsFromEnv()
+	contexts, clusters, users := definitions{}, definitions{}, definitions{}
+	merged := api.NewConfig()
+	currentFrom := ""
+	for _, fPath := range paths {
+		cfg, err := readOrNew(fPath)
+		if err != nil {
+			return "", errors.Wrapf(err, "read %s", fPath)
+		}
+		if merged.CurrentContext == "" && cfg.CurrentContext != "" {
+			merged.CurrentContext = cfg.CurrentContext
+			currentFrom = fPath
+		}
+		for name, context := range cfg.Contexts {
+			if _, ok := merged.Contexts[name]; !ok {
+				merged.Contexts[name] = context
+			}
+			contexts[name] = append(contexts[name], fPath)
+		}
+		for name, cluster := range cfg.Clusters {
+			if _, ok := merged.Clusters[name]; !ok {
+				merged.Clusters[name] = cluster
+			}
+			clusters[name] = append(clusters[name], fPath)
+		}
+		for name := range cfg.AuthInfos {
+			users[name] = append(users[name], fPath)
+		}
+	}
+
+	lines := []string{fmt.Sprintf("KUBECONFIG files in precedence order: %s", strings.Join(paths, ", "))}
+	if contextName == "" {
+		if merged.CurrentContext == "" {
+			return "", errors.Errorf("no current-context is set in %s", strings.Join(paths, ", "))
+		}
+		contextName = merged.CurrentContext
+		lines = append(lines, fmt.Sprintf("current-context %q: from %s", contextName, currentFrom))
+	}
+
+	context, ok := merged.Contexts[contextName]
+	if !ok {
+		return "", errors.Errorf("%q does not appear in %s", contextName, strings.Join(paths, ", "))
+	}
+	lines = append(lines, contexts.describe("context", contextName))
+
+	cluster := clusters.describe("cluster", context.Cluster)
+	if c, ok := merged.Clusters[context.Cluster]; ok {
+		cluster += fmt.Sprintf(", server %s", c.Server)
+	}
+	lines = append(lines, cluster, users.describe("user", context.AuthInfo))
+
+	if context.Namespace == "" {
+		lines = append(lines, `namespace "default": not set in the context`)
+	} else {
+		lines = append(lines, fmt.Sprintf("namespace %q: from context %q in %s", context.Namespace, contextName, contexts[contextName][0]))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/minikube/pkg/minikube/constants"
+)
+
+func TestExplainContext(t *testing.T) {
+	fn192 := tempFile(t, kubeConfig192)
+	defer os.Remove(fn192)
+	fnLocalhost := tempFile(t, kubeConfigLocalhost)
+	defer os.Remove(fnLocalhost)
+	t.Setenv(constants.KubeconfigEnvVar, strings.Join([]string{fn192, fnLocalhost}, string(filepath.ListSeparator)))
+
+	got, err := ExplainContext("")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		fmt.Sprintf(`current-context "minikube": from %s`, fn192),
+		fmt.Sprintf(`context "minikube": from %s (shadows %s)`, fn192, fnLocalhost),
+		"server https://192.168.10.100:8443",
+		fmt.Sprintf(`user "minikube": from %s (shadows %s)`, fn192, fnLocalhost),
+		`namespace "default": not set in the context`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected explanation to contain %q, got:\n%s", want, got)
+		}
+	}
+
+	if _, err := ExplainContext("missing"); err == nil {
+		t.Errorf("Expected error for missing context but got none")
+	}
+}
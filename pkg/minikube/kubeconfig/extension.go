@@ -17,32 +17,175 @@ limitations under the License.
 package kubeconfig
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/blang/semver/v4"
+	"github.com/pkg/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
 	"k8s.io/minikube/pkg/version"
 )
 
+// Managed is the provider recorded in the extensions of kubeconfig entries written by minikube.
+// Every ownership check compares against it, so a fork only needs to change it here.
+var Managed = "minikube.sigs.k8s.io"
+
+const (
+	// clusterExtensionKey is the name of the extension minikube adds to clusters
+	clusterExtensionKey = "cluster_info"
+	// contextExtensionKey is the name of the extension minikube adds to contexts
+	contextExtensionKey = "context_info"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 // implementing the runtime.Object internally so we can write extensions to kubeconfig
 
 // Extension represents information to identify clusters and contexts
 type Extension struct {
-	runtime.TypeMeta `json:",inline"`
-	Version          string `json:"version"`
-	Provider         string `json:"provider"`
-	LastUpdate       string `json:"last-update"`
+	runtime.TypeMeta  `json:",inline"`
+	Version           string `json:"version"`
+	Provider          string `json:"provider"`
+	LastUpdate        string `json:"last-update"`
+	Profile           string `json:"profile,omitempty"`
+	Driver            string `json:"driver,omitempty"`
+	Env               string `json:"env,omitempty"`
+	ProxyCAData       []byte `json:"proxy-ca-data,omitempty"`
+	KubernetesVersion string `json:"kubernetes-version,omitempty"`
+	OriginalServer    string `json:"original-server,omitempty"`
 }
 
 // NewExtension returns a minikube formatted kubeconfig's extension block to idenity clusters and contexts
 func NewExtension() *Extension {
 	return &Extension{
-		Provider: "minikube.sigs.k8s.io",
+		Provider: Managed,
 		Version:  version.GetVersion(),
 		// time format matching other RFC in notify.go
 		LastUpdate: time.Now().Format(time.RFC1123)}
 }
 
+// managed returns whether e marks its entry as written by Managed
+func (e *Extension) managed() bool {
+	return e != nil && e.Provider == Managed
+}
+
+// decodeExtension returns the minikube extension stored under key, or nil if there is none.
+// Extensions read back from a kubeconfig file are decoded as *runtime.Unknown rather than *Extension.
+func decodeExtension(exts map[string]runtime.Object, key string) (*Extension, error) {
+	obj, ok := exts[key]
+	if !ok || obj == nil {
+		return nil, nil
+	}
+	switch e := obj.(type) {
+	case *Extension:
+		return e, nil
+	case *runtime.Unknown:
+		ext := &Extension{}
+		if err := json.Unmarshal(e.Raw, ext); err != nil {
+			return nil, errors.Wrapf(err, "decoding %s extension", key)
+		}
+		return ext, nil
+	}
+	return nil, fmt.Errorf("unexpected %s extension type %T", key, obj)
+}
+
+// NeedsMigration returns the minikube contexts whose recorded extension version is older than current.
+// Contexts without a parsable version predate versioning and are returned as well.
+func NeedsMigration(kubeConfigPath string, current string) ([]string, error) {
+	cur, err := semver.ParseTolerant(current)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing version %q", current)
+	}
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	var names []string
+	for name, ctx := range cfg.Contexts {
+		ext, err := decodeExtension(ctx.Extensions, contextExtensionKey)
+		if err != nil {
+			klog.Warningf("skipping context %q: %v", name, err)
+			continue
+		}
+		if !ext.managed() {
+			continue
+		}
+		v, err := semver.ParseTolerant(ext.Version)
+		if err != nil || v.LT(cur) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RawExtension returns the serialized extension stored under key on contextName, without decoding it.
+func RawExtension(contextName, key, kubeConfigPath string) ([]byte, error) {
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	ctx, ok := cfg.Contexts[contextName]
+	if !ok {
+		return nil, errors.Errorf("%q does not appear in %s", contextName, kubeConfigPath)
+	}
+	obj, ok := ctx.Extensions[key]
+	if !ok || obj == nil {
+		return nil, errors.Errorf("context %q has no %q extension", contextName, key)
+	}
+	if u, ok := obj.(*runtime.Unknown); ok {
+		return u.Raw, nil
+	}
+	return json.Marshal(obj)
+}
+
+// ProxyCAFor returns the CA of the TLS-terminating proxy recorded for the cluster behind contextName.
+// It returns nil if no proxy CA was recorded.
+func ProxyCAFor(contextName, kubeConfigPath string) ([]byte, error) {
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	ctx, ok := cfg.Contexts[contextName]
+	if !ok {
+		return nil, errors.Errorf("%q does not appear in %s", contextName, kubeConfigPath)
+	}
+	cluster, ok := cfg.Clusters[ctx.Cluster]
+	if !ok {
+		return nil, errors.Errorf("cluster %q of context %q does not appear in %s", ctx.Cluster, contextName, kubeConfigPath)
+	}
+	ext, err := decodeExtension(cluster.Extensions, clusterExtensionKey)
+	if err != nil || ext == nil {
+		return nil, err
+	}
+	return ext.ProxyCAData, nil
+}
+
+// KubernetesVersionFor returns the Kubernetes version recorded for the cluster behind contextName.
+// It returns "" if no version was recorded, e.g. for clusters written by older minikube versions.
+func KubernetesVersionFor(contextName, kubeConfigPath string) (string, error) {
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return "", errors.Wrap(err, "read")
+	}
+	ctx, ok := cfg.Contexts[contextName]
+	if !ok {
+		return "", errors.Errorf("%q does not appear in %s", contextName, kubeConfigPath)
+	}
+	cluster, ok := cfg.Clusters[ctx.Cluster]
+	if !ok {
+		return "", errors.Errorf("cluster %q of context %q does not appear in %s", ctx.Cluster, contextName, kubeConfigPath)
+	}
+	ext, err := decodeExtension(cluster.Extensions, clusterExtensionKey)
+	if err != nil || ext == nil {
+		return "", err
+	}
+	return ext.KubernetesVersion, nil
+}
+
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Extension.
 func (in *Extension) DeepCopy() *Extension {
 	if in == nil {
@@ -62,4 +205,9 @@ func (in *Extension) DeepCopyObject() runtime.Object {
 func (in *Extension) DeepCopyInto(out *Extension) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
+	if in.ProxyCAData != nil {
+		in, out := &in.ProxyCAData, &out.ProxyCAData
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
 }
@@ -0,0 +1,222 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var kubeConfigVersions = []byte(`
+apiVersion: v1
+clusters:
+- cluster:
+    server: https://192.168.10.100:8443
+  name: minikube
+contexts:
+- context:
+    cluster: minikube
+    extensions:
+    - extension:
+        last-update: Mon, 02 Jan 2023 15:04:05 UTC
+        provider: minikube.sigs.k8s.io
+        version: v1.27.1
+      name: context_info
+    user: minikube
+  name: old
+- context:
+    cluster: minikube
+    extensions:
+    - extension:
+        last-update: Mon, 02 Jan 2023 15:04:05 UTC
+        provider: minikube.sigs.k8s.io
+        version: v1.28.0
+      name: context_info
+    user: minikube
+  name: current
+- context:
+    cluster: minikube
+    extensions:
+    - extension:
+        provider: minikube.sigs.k8s.io
+      name: context_info
+    user: minikube
+  name: unversioned
+- context:
+    cluster: minikube
+    user: minikube
+  name: foreign
+current-context: current
+kind: Config
+preferences: {}
+users:
+- name: minikube
+  user:
+    client-certificate: /home/la-croix/apiserver.crt
+    client-key: /home/la-croix/apiserver.key
+`)
+
+func TestNeedsMigration(t *testing.T) {
+	configFilename := tempFile(t, kubeConfigVersions)
+	defer os.Remove(configFilename)
+
+	got, err := NeedsMigration(configFilename, "v1.28.0")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	want := []string{"old", "unversioned"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := NeedsMigration(configFilename, "not-a-version"); err == nil {
+		t.Errorf("Expected error for invalid version but got none")
+	}
+}
+
+func TestRawExtension(t *testing.T) {
+	configFilename := tempFile(t, kubeConfigVersions)
+	defer os.Remove(configFilename)
+
+	raw, err := RawExtension("old", contextExtensionKey, configFilename)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	want := `{"last-update":"Mon, 02 Jan 2023 15:04:05 UTC","provider":"minikube.sigs.k8s.io","version":"v1.27.1"}`
+	if string(raw) != want {
+		t.Errorf("got %s, want %s", raw, want)
+	}
+
+	if _, err := RawExtension("foreign", contextExtensionKey, configFilename); err == nil {
+		t.Errorf("Expected error for context without extension but got none")
+	}
+	if _, err := RawExtension("missing", contextExtensionKey, configFilename); err == nil {
+		t.Errorf("Expected error for missing context but got none")
+	}
+}
+
+func TestProxyCAFor(t *testing.T) {
+	dir := t.TempDir()
+	proxyCA := filepath.Join(dir, "proxy-ca.crt")
+	if err := os.WriteFile(proxyCA, []byte("proxy ca"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+		ProxyCA:              proxyCA,
+	}
+	kcs.SetPath(filepath.Join(dir, "config"))
+	if err := Update(kcs); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	got, err := ProxyCAFor("minikube", kcs.filePath())
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if string(got) != "proxy ca" {
+		t.Errorf("got proxy CA %q, want %q", got, "proxy ca")
+	}
+
+	configFilename := tempFile(t, kubeConfig192)
+	defer os.Remove(configFilename)
+	got, err = ProxyCAFor("minikube", configFilename)
+	if err != nil || got != nil {
+		t.Errorf("Expected no proxy CA, got %q, %v", got, err)
+	}
+}
+
+func TestKubernetesVersionFor(t *testing.T) {
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+		KubernetesVersion:    "v1.28.3",
+	}
+	kcs.SetPath(filepath.Join(t.TempDir(), "config"))
+	if err := Update(kcs); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	got, err := KubernetesVersionFor("minikube", kcs.filePath())
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if got != "v1.28.3" {
+		t.Errorf("got version %q, want %q", got, "v1.28.3")
+	}
+
+	configFilename := tempFile(t, kubeConfigVersions)
+	defer os.Remove(configFilename)
+	got, err = KubernetesVersionFor("old", configFilename)
+	if err != nil || got != "" {
+		t.Errorf("Expected no version, got %q, %v", got, err)
+	}
+	if _, err := KubernetesVersionFor("missing", configFilename); err == nil {
+		t.Errorf("Expected error for missing context but got none")
+	}
+}
+
+func TestProfileAndDriver(t *testing.T) {
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+		Profile:              "dev",
+		Driver:               "docker",
+	}
+	kcs.SetPath(filepath.Join(t.TempDir(), "config"))
+	if err := Update(kcs); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	cfg, err := readOrNew(kcs.filePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, exts := range map[string]map[string]runtime.Object{
+		clusterExtensionKey: cfg.Clusters["minikube"].Extensions,
+		contextExtensionKey: cfg.Contexts["minikube"].Extensions,
+	} {
+		ext, err := decodeExtension(exts, key)
+		if err != nil || ext == nil {
+			t.Fatalf("Expected %s extension, got %v", key, err)
+		}
+		if ext.Profile != "dev" || ext.Driver != "docker" {
+			t.Errorf("got %s profile %q and driver %q, want dev and docker", key, ext.Profile, ext.Driver)
+		}
+	}
+
+	// entries written before these fields existed still parse
+	configFilename := tempFile(t, kubeConfigVersions)
+	defer os.Remove(configFilename)
+	old, err := readOrNew(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext, err := decodeExtension(old.Contexts["old"].Extensions, contextExtensionKey)
+	if err != nil || ext == nil || ext.Profile != "" || ext.Driver != "" {
+		t.Errorf("Expected an extension without profile and driver, got %+v, %v", ext, err)
+	}
+}
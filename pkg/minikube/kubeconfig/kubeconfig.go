@@ -17,17 +17,24 @@ limitations under the License.
 package kubeconfig
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/tools/clientcmd/api/latest"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
 	"k8s.io/klog/v2"
 	"k8s.io/minikube/pkg/minikube/constants"
 	"k8s.io/minikube/pkg/minikube/localpath"
@@ -35,29 +42,73 @@ import (
 	"k8s.io/minikube/pkg/util/lock"
 )
 
-// VerifyEndpoint verifies the IP:port stored in kubeconfig.
-func VerifyEndpoint(contextName string, hostname string, port int, configPath ...string) error {
-	path := PathFromEnv()
-	if configPath != nil {
-		path = configPath[0]
-	}
+// SortContexts orders the context names of a kubeconfig in place before it is written.
+// Clusters and users are always written in lexical order.
+var SortContexts = sort.Strings
 
-	if hostname == "" {
-		return fmt.Errorf("empty IP")
-	}
+// VerifyAfterWrite makes every kubeconfig write read the written data back and compare it to the intended config
+// before it replaces the previous file, so truncation or corruption is caught right away.
+var VerifyAfterWrite = false
+
+// MaxConfigBytes is the size above which a kubeconfig file is refused rather than loaded into memory
+var MaxConfigBytes int64 = 10 << 20
+
+// ErrClusterNotFound is returned by VerifyEndpoint if the kubeconfig has no cluster of the given name
+var ErrClusterNotFound = errors.New("cluster not found")
 
-	gotHostname, gotPort, err := Endpoint(contextName, path)
+// VerifyEndpoint returns an error if the server stored for clusterName in kubeConfigFile is not expectedURL.
+// Both are compared after normalizing the scheme, which defaults to https, and trailing slashes.
+// If there is no such cluster, the error wraps ErrClusterNotFound.
+func VerifyEndpoint(kubeConfigFile, clusterName, expectedURL string) error {
+	if expectedURL == "" {
+		return fmt.Errorf("empty expected URL")
+	}
+	want, err := normalizeServer(expectedURL)
 	if err != nil {
-		return errors.Wrap(err, "extract IP")
+		return errors.Wrapf(err, "parsing expected URL %q", expectedURL)
 	}
 
-	if hostname != gotHostname || port != gotPort {
-		return fmt.Errorf("got: %s:%d, want: %s:%d", gotHostname, gotPort, hostname, port)
+	cfg, err := readOrNew(kubeConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "read")
+	}
+	cluster, ok := cfg.Clusters[clusterName]
+	if !ok {
+		return errors.Wrapf(ErrClusterNotFound, "%q does not appear in %s", clusterName, kubeConfigFile)
+	}
+	server := realServer(cluster)
+	got, err := normalizeServer(server)
+	if err != nil {
+		return errors.Wrapf(err, "parsing server %q of %q", server, clusterName)
 	}
 
+	if got != want {
+		return fmt.Errorf("%q in %s points at %s, want %s", clusterName, kubeConfigFile, server, expectedURL)
+	}
 	return nil
 }
 
+// realServer returns the server of cluster, or the one it had before MINIKUBE_SERVER_OVERRIDE replaced it
+func realServer(cluster *api.Cluster) string {
+	ext, err := decodeExtension(cluster.Extensions, clusterExtensionKey)
+	if err == nil && ext != nil && ext.OriginalServer != "" {
+		return ext.OriginalServer
+	}
+	return cluster.Server
+}
+
+// normalizeServer returns server with a lower case scheme and host, defaulting to https, and without trailing slashes
+func normalizeServer(server string) (string, error) {
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + strings.TrimRight(u.Path, "/"), nil
+}
+
 // PathFromEnv gets the path to the first kubeconfig
 func PathFromEnv() string {
 	kubeConfigEnv := os.Getenv(constants.KubeconfigEnvVar)
@@ -74,48 +125,108 @@ func PathFromEnv() string {
 	return constants.KubeconfigPath
 }
 
+// PathListFromEnv gets the paths of all kubeconfig files as a KUBECONFIG style list, for Settings.SetPath
+// to let Update write to the first writable one of them
+func PathListFromEnv() string {
+	return strings.Join(pathsFromEnv(), string(filepath.ListSeparator))
+}
+
+// WritablePathFromEnv gets the kubeconfig file Update writes to, the first writable one of KUBECONFIG,
+// so that the entries of minikube are read and changed where they were written
+func WritablePathFromEnv() string {
+	fPath, err := resolveWritePath(PathListFromEnv())
+	if err != nil {
+		klog.Warningf("unable to find a writable kubeconfig: %v", err)
+		return PathFromEnv()
+	}
+	return fPath
+}
+
+// pathsFromEnv gets the paths of all kubeconfig files, in the order kubectl merges them
+func pathsFromEnv() []string {
+	kubeConfigEnv := os.Getenv(constants.KubeconfigEnvVar)
+	paths := []string{}
+	for _, kubeConfigFile := range filepath.SplitList(kubeConfigEnv) {
+		if kubeConfigFile != "" {
+			paths = append(paths, kubeConfigFile)
+		}
+	}
+	if len(paths) == 0 {
+		return []string{constants.KubeconfigPath}
+	}
+	return paths
+}
+
 // Endpoint returns the IP:port address stored for minikube in the kubeconfig specified
 func Endpoint(contextName string, configPath ...string) (string, int, error) {
+	_, u, err := EndpointURL(contextName, configPath...)
+	if err != nil {
+		return "", 0, err
+	}
+
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return "", 0, errors.Wrap(err, "atoi")
+	}
+
+	return u.Hostname(), port, nil
+}
+
+// EndpointURL returns the full server address stored for minikube in the kubeconfig specified,
+// both as written and parsed, so that a path prefix of the apiserver is preserved.
+// This is the address kubectl connects to, i.e. MINIKUBE_SERVER_OVERRIDE if it was set by Update.
+func EndpointURL(contextName string, configPath ...string) (string, *url.URL, error) {
 	path := PathFromEnv()
 	if configPath != nil {
 		path = configPath[0]
 	}
 	apiCfg, err := readOrNew(path)
 	if err != nil {
-		return "", 0, errors.Wrap(err, "read")
+		return "", nil, errors.Wrap(err, "read")
 	}
 	cluster, ok := apiCfg.Clusters[contextName]
 	if !ok {
-		return "", 0, errors.Errorf("%q does not appear in %s", contextName, path)
+		return "", nil, errors.Errorf("%q does not appear in %s", contextName, path)
 	}
 
 	klog.Infof("found %q server: %q", contextName, cluster.Server)
 	u, err := url.Parse(cluster.Server)
 	if err != nil {
-		return "", 0, errors.Wrap(err, "url parse")
-	}
-
-	port, err := strconv.Atoi(u.Port())
-	if err != nil {
-		return "", 0, errors.Wrap(err, "atoi")
+		return "", nil, errors.Wrap(err, "url parse")
 	}
 
-	return u.Hostname(), port, nil
+	return cluster.Server, u, nil
 }
 
 // verifyKubeconfig verifies that the cluster and context entries in the kubeconfig are valid
 func verifyKubeconfig(contextName string, hostname string, port int, configPath ...string) error {
-	if err := VerifyEndpoint(contextName, hostname, port, configPath...); err != nil {
-		return err
-	}
 	path := PathFromEnv()
 	if configPath != nil {
 		path = configPath[0]
 	}
+	if hostname == "" {
+		return fmt.Errorf("empty IP")
+	}
 	apiCfg, err := readOrNew(path)
 	if err != nil {
 		return errors.Wrap(err, "read")
 	}
+	cluster, ok := apiCfg.Clusters[contextName]
+	if !ok {
+		return errors.Errorf("%q does not appear in %s", contextName, path)
+	}
+	u, err := url.Parse(realServer(cluster))
+	if err != nil {
+		return errors.Wrap(err, "url parse")
+	}
+	gotPort, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return errors.Wrap(err, "extract IP")
+	}
+	if hostname != u.Hostname() || port != gotPort {
+		return fmt.Errorf("got: %s:%d, want: %s:%d", u.Hostname(), gotPort, hostname, port)
+	}
+
 	if _, ok := apiCfg.Contexts[contextName]; !ok {
 		return errors.Errorf("%q does not appear in %s", contextName, path)
 	}
@@ -155,6 +266,9 @@ func UpdateEndpoint(contextName string, hostname string, port int, confpath stri
 			KeepContext:          false,
 		}
 		if ext != nil {
+			if ext.Profile == "" {
+				ext.Profile = contextName
+			}
 			kcs.ExtensionCluster = ext
 		}
 		err = PopulateFromSettings(kcs, cfg)
@@ -162,7 +276,14 @@ func UpdateEndpoint(contextName string, hostname string, port int, confpath stri
 			return false, errors.Wrap(err, "populating kubeconfig")
 		}
 	} else {
-		cfg.Clusters[contextName].Server = address
+		cluster := cfg.Clusters[contextName]
+		// keep pointing at MINIKUBE_SERVER_OVERRIDE, only the server it stands in for moved
+		if ext, err := decodeExtension(cluster.Extensions, clusterExtensionKey); err == nil && ext != nil && ext.OriginalServer != "" {
+			ext.OriginalServer = address
+			cluster.Extensions[clusterExtensionKey] = ext
+		} else {
+			cluster.Server = address
+		}
 	}
 
 	err = writeToFile(cfg, confpath)
@@ -173,6 +294,92 @@ func UpdateEndpoint(contextName string, hostname string, port int, confpath stri
 	return true, nil
 }
 
+// Snippet returns a kubeconfig document holding only contextName and the cluster and user it references,
+// ready to be pasted e.g. into an issue. With redact, keys, tokens and passwords are replaced by REDACTED.
+func Snippet(contextName, kubeConfigPath string, redact bool) (string, error) {
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return "", errors.Wrap(err, "read")
+	}
+	if _, ok := cfg.Contexts[contextName]; !ok {
+		return "", errors.Errorf("%q does not appear in %s", contextName, kubeConfigPath)
+	}
+
+	cfg.CurrentContext = contextName
+	if err := api.MinifyConfig(cfg); err != nil {
+		return "", errors.Wrapf(err, "minify %q", contextName)
+	}
+	if redact {
+		if err := api.RedactSecrets(cfg); err != nil {
+			return "", errors.Wrap(err, "redact")
+		}
+	}
+	data, err := encode(cfg)
+	if err != nil {
+		return "", errors.Wrap(err, "encode")
+	}
+	return string(data), nil
+}
+
+// DedupClusters collapses minikube-owned cluster entries that are identical in every field into
+// the first of them by name, repointing the contexts that referenced the others.
+// It returns the number of cluster entries removed.
+func DedupClusters(kubeConfigPath string) (int, error) {
+	releaser, err := acquireLock(kubeConfigPath)
+	if err != nil {
+		return 0, err
+	}
+	defer releaser.Release()
+
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "read")
+	}
+
+	names := []string{}
+	for name := range cfg.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	kept := map[string]string{}
+	replaced := map[string]string{}
+	for _, name := range names {
+		cluster := cfg.Clusters[name]
+		ext, err := decodeExtension(cluster.Extensions, clusterExtensionKey)
+		if err != nil || !ext.managed() {
+			continue
+		}
+		data, err := json.Marshal(cluster)
+		if err != nil {
+			return 0, errors.Wrapf(err, "marshal cluster %q", name)
+		}
+		if first, ok := kept[string(data)]; ok {
+			replaced[name] = first
+			continue
+		}
+		kept[string(data)] = name
+	}
+	if len(replaced) == 0 {
+		return 0, nil
+	}
+
+	for name, first := range replaced {
+		klog.Infof("cluster %q is identical to %q, removing it", name, first)
+		delete(cfg.Clusters, name)
+	}
+	for _, context := range cfg.Contexts {
+		if first, ok := replaced[context.Cluster]; ok {
+			context.Cluster = first
+		}
+	}
+
+	if err := writeToFile(cfg, kubeConfigPath); err != nil {
+		return 0, errors.Wrap(err, "write")
+	}
+	return len(replaced), nil
+}
+
 func configNeedsRepair(contextName string, cfg *api.Config) bool {
 	if _, ok := cfg.Clusters[contextName]; !ok {
 		return true
@@ -195,29 +402,179 @@ func writeToFile(config runtime.Object, configPath ...string) error {
 		klog.Errorf("could not write to '%s': config can't be nil", fPath)
 	}
 
-	// encode config to YAML
-	data, err := runtime.Encode(latest.Codec, config)
-	if err != nil {
-		return errors.Errorf("could not write to '%s': failed to encode config: %v", fPath, err)
+	return writeToFiles(context.Background(), map[string]runtime.Object{fPath: config})
+}
+
+// writeToFiles encodes each configuration and writes it to its path with all-or-nothing semantics.
+// Every config is first staged to a temporary file next to its target, and the targets are only
+// replaced once all of them were staged successfully. Staged files are removed on any failure.
+func writeToFiles(ctx context.Context, configs map[string]runtime.Object) error {
+	contents := map[string][]byte{}
+	for fPath, config := range configs {
+		// encode config to YAML
+		data, err := encode(config)
+		if err != nil {
+			return errors.Errorf("could not write to '%s': failed to encode config: %v", fPath, err)
+		}
+		contents[fPath] = data
+	}
+	return writeDataToFiles(ctx, contents)
+}
+
+// writeDataToFiles writes already encoded kubeconfig contents with the semantics of writeToFiles
+func writeDataToFiles(ctx context.Context, contents map[string][]byte) error {
+	paths := []string{}
+	for fPath := range contents {
+		paths = append(paths, fPath)
+	}
+	// lock in a stable order so concurrent multi-file writers can't deadlock
+	sort.Strings(paths)
+
+	for _, fPath := range paths {
+		releaser, err := acquireContext(ctx, lock.PathMutexSpec(fPath), fPath)
+		if err != nil {
+			return err
+		}
+		defer releaser.Release()
+	}
+
+	staged := map[string]string{}
+	defer func() {
+		for _, tmp := range staged {
+			if err := os.Remove(tmp); err != nil {
+				klog.Warningf("failed to remove staged file %s: %v", tmp, err)
+			}
+		}
+	}()
+
+	for _, fPath := range paths {
+		tmp, err := stageFile(contents[fPath], fPath)
+		if err != nil {
+			return err
+		}
+		staged[fPath] = tmp
+		if VerifyAfterWrite {
+			if err := verifyWrite(tmp, contents[fPath]); err != nil {
+				return errors.Wrapf(err, "verifying write of %s", fPath)
+			}
+		}
 	}
 
-	// create parent dir if doesn't exist
+	for _, fPath := range paths {
+		target := fPath
+		// replace the file a symlink points to rather than the symlink itself
+		if resolved, err := filepath.EvalSymlinks(fPath); err == nil {
+			target = resolved
+		}
+		if err := backupFile(target, fPath+".bak"); err != nil {
+			return err
+		}
+		// the staged file is created 0600 and owned by us, keep what the replaced file had
+		if fi, err := os.Stat(target); err == nil {
+			keepOwner(staged[fPath], fi)
+			if err := os.Chmod(staged[fPath], fi.Mode().Perm()); err != nil {
+				return errors.Wrapf(err, "Error keeping the mode of %s", fPath)
+			}
+		}
+		// the original is left untouched if the rename fails
+		if err := os.Rename(staged[fPath], target); err != nil {
+			return errors.Wrapf(err, "Error writing file %s", fPath)
+		}
+		delete(staged, fPath)
+		syncDir(filepath.Dir(target))
+
+		dir := filepath.Dir(fPath)
+		if err := pkgutil.MaybeChownDirRecursiveToMinikubeUser(dir); err != nil {
+			return errors.Wrapf(err, "Error recursively changing ownership for dir: %s", dir)
+		}
+	}
+
+	return nil
+}
+
+// stageFile writes data into a temporary file next to fPath and returns the path of the temporary file
+func stageFile(data []byte, fPath string) (string, error) {
+	// create parent dir if doesn't exist, e.g. ~/.kube on a fresh machine
 	dir := filepath.Dir(fPath)
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		if err = os.MkdirAll(dir, 0755); err != nil {
-			return errors.Wrapf(err, "Error creating directory: %s", dir)
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return "", errors.Wrapf(err, "Error creating directory: %s", dir)
 		}
 	}
 
-	// write with restricted permissions
-	if err := lock.WriteFile(fPath, data, 0600); err != nil {
-		return errors.Wrapf(err, "Error writing file %s", fPath)
+	// the temp file is created with restricted permissions
+	f, err := os.CreateTemp(dir, filepath.Base(fPath)+".tmp")
+	if err != nil {
+		return "", errors.Wrapf(err, "Error creating temp file for %s", fPath)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", errors.Wrapf(err, "Error writing temp file for %s", fPath)
+	}
+	// flush to disk before the rename, so a crash can't leave a renamed but empty file
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", errors.Wrapf(err, "Error syncing temp file for %s", fPath)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrapf(err, "Error closing temp file for %s", fPath)
+	}
+	return f.Name(), nil
+}
+
+// backupFile atomically replaces backup with the current contents of fPath, if fPath exists
+func backupFile(fPath, backup string) error {
+	data, err := os.ReadFile(fPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Error reading %s for backup", fPath)
+	}
+	tmp, err := stageFile(data, backup)
+	if err != nil {
+		return errors.Wrapf(err, "Error backing up %s", fPath)
+	}
+	if err := os.Rename(tmp, backup); err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "Error backing up %s", fPath)
 	}
+	return nil
+}
 
-	if err := pkgutil.MaybeChownDirRecursiveToMinikubeUser(dir); err != nil {
-		return errors.Wrapf(err, "Error recursively changing ownership for dir: %s", dir)
+// syncDir flushes the directory entry of a rename to disk. Not every platform supports this, so failures are only logged.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		klog.V(2).Infof("unable to open %s for sync: %v", dir, err)
+		return
 	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		klog.V(2).Infof("unable to sync %s: %v", dir, err)
+	}
+}
 
+// verifyWrite checks that the config read back from fPath matches the config encoded in data
+func verifyWrite(fPath string, data []byte) error {
+	written, err := os.ReadFile(fPath)
+	if err != nil {
+		return errors.Wrap(err, "reading back")
+	}
+	got, err := decode(written)
+	if err != nil {
+		return errors.Wrap(err, "decoding written config")
+	}
+	want, err := decode(data)
+	if err != nil {
+		return errors.Wrap(err, "decoding intended config")
+	}
+	if !reflect.DeepEqual(got, want) {
+		return errors.Errorf("written config does not match: read %d bytes, wanted %d", len(written), len(data))
+	}
 	return nil
 }
 
@@ -229,7 +586,7 @@ func readOrNew(configPath ...string) (*api.Config, error) {
 		fPath = configPath[0]
 	}
 
-	data, err := os.ReadFile(fPath)
+	data, err := readLimited(fPath)
 	if os.IsNotExist(err) {
 		return api.NewConfig(), nil
 	} else if err != nil {
@@ -256,6 +613,24 @@ func readOrNew(configPath ...string) (*api.Config, error) {
 	return kcfg, nil
 }
 
+// readLimited reads fPath, failing instead of reading more than MaxConfigBytes
+func readLimited(fPath string) ([]byte, error) {
+	f, err := os.Open(fPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, MaxConfigBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxConfigBytes {
+		return nil, errors.Errorf("file is larger than the %d bytes allowed for a kubeconfig", MaxConfigBytes)
+	}
+	return data, nil
+}
+
 // decode reads a Config object from bytes.
 // Returns empty config if no bytes.
 func decode(data []byte) (*api.Config, error) {
@@ -271,3 +646,41 @@ func decode(data []byte) (*api.Config, error) {
 
 	return kcfg.(*api.Config), nil
 }
+
+// encode writes a Config object to bytes.
+// Empty clusters, contexts and users are written as [] rather than null.
+func encode(config runtime.Object) ([]byte, error) {
+	kcfg, ok := config.(*api.Config)
+	if !ok {
+		return runtime.Encode(latest.Codec, config)
+	}
+
+	v1cfg := &v1.Config{}
+	if err := latest.Scheme.Convert(kcfg, v1cfg, nil); err != nil {
+		return nil, errors.Wrap(err, "converting config")
+	}
+	if v1cfg.Clusters == nil {
+		v1cfg.Clusters = []v1.NamedCluster{}
+	}
+	if v1cfg.AuthInfos == nil {
+		v1cfg.AuthInfos = []v1.NamedAuthInfo{}
+	}
+	if v1cfg.Contexts == nil {
+		v1cfg.Contexts = []v1.NamedContext{}
+	}
+
+	names := []string{}
+	for _, c := range v1cfg.Contexts {
+		names = append(names, c.Name)
+	}
+	SortContexts(names)
+	order := map[string]int{}
+	for i, name := range names {
+		order[name] = i
+	}
+	sort.SliceStable(v1cfg.Contexts, func(i, j int) bool {
+		return order[v1cfg.Contexts[i].Name] < order[v1cfg.Contexts[j].Name]
+	})
+
+	return runtime.Encode(latest.Codec, v1cfg)
+}
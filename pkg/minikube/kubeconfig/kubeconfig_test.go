@@ -17,14 +17,24 @@ limitations under the License.
 package kubeconfig
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	goruntime "runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/juju/mutex"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/minikube/pkg/minikube/constants"
 	"k8s.io/minikube/pkg/minikube/localpath"
+	"k8s.io/minikube/pkg/util/lock"
 
 	"k8s.io/client-go/tools/clientcmd"
 )
@@ -324,58 +334,1167 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+func TestUpdatePanicReleasesLock(t *testing.T) {
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+	}
+	kcs.SetPath(filepath.Join(t.TempDir(), "config"))
+
+	populateSettings = func(*Settings, *api.Config) error { panic("injected") }
+	func() {
+		defer func() {
+			populateSettings = PopulateFromSettings
+			if r := recover(); r == nil {
+				t.Errorf("Expected Update to panic")
+			}
+		}()
+		_ = Update(kcs)
+	}()
+
+	spec := lock.PathMutexSpec(filepath.Join(kcs.filePath(), "settings.Update"))
+	spec.Timeout = 5 * time.Second
+	releaser, err := mutex.Acquire(spec)
+	if err != nil {
+		t.Fatalf("lock was not released after the panic: %v", err)
+	}
+	releaser.Release()
+
+	if err := Update(kcs); err != nil {
+		t.Errorf("Got unexpected error: %v", err)
+	}
+}
+
+func TestUpdateWithContextLockTimeout(t *testing.T) {
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+	}
+	kcs.SetPath(filepath.Join(t.TempDir(), "config"))
+
+	releaser, err := acquireLock(kcs.filePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = UpdateWithContext(ctx, kcs)
+	releaser.Release()
+	if err == nil || !strings.Contains(err.Error(), "timed out") || !strings.Contains(err.Error(), kcs.filePath()) {
+		t.Errorf("Expected a timeout naming %s but got %v", kcs.filePath(), err)
+	}
+
+	// the lock taken while writing the file honors ctx as well
+	releaser, err = mutex.Acquire(lock.PathMutexSpec(kcs.filePath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	err = UpdateWithContext(ctx, kcs)
+	releaser.Release()
+	if err == nil || !strings.Contains(err.Error(), "timed out") || !strings.Contains(err.Error(), kcs.filePath()) {
+		t.Errorf("Expected a timeout naming %s but got %v", kcs.filePath(), err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("UpdateWithContext waited %v, past its deadline", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := UpdateWithContext(cancelled, kcs); err == nil {
+		t.Errorf("Expected an error for a cancelled context")
+	}
+
+	if err := UpdateWithContext(context.Background(), kcs); err != nil {
+		t.Errorf("Got unexpected error: %v", err)
+	}
+}
+
+func TestUpdateProfileDir(t *testing.T) {
+	dir := t.TempDir()
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+		ProfileDir:           filepath.Join(dir, "minikube"),
+	}
+	kcs.SetPath(filepath.Join(dir, "config"))
+	if err := os.WriteFile(kcs.filePath(), kubeConfigWithoutHTTPS, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Update(kcs); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	main, err := readOrNew(kcs.filePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(main.Contexts) != 2 {
+		t.Errorf("Expected both contexts in the main config, got %v", main.Contexts)
+	}
+
+	profile, err := readOrNew(filepath.Join(dir, "minikube", "minikube.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.Contexts) != 1 || len(profile.Clusters) != 1 || len(profile.AuthInfos) != 1 {
+		t.Errorf("Expected only the minikube entries in the profile config, got %+v", profile)
+	}
+	if profile.CurrentContext != "minikube" {
+		t.Errorf("got profile current context %q, want minikube", profile.CurrentContext)
+	}
+}
+
+func TestUpdateCAOutputPath(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on windows")
+	}
+
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(ca, []byte("ca pem"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := filepath.Join(dir, "client")
+	if err := os.WriteFile(client, []byte("client"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, embed := range []bool{true, false} {
+		out := filepath.Join(dir, fmt.Sprintf("ca-%t.pem", embed))
+		kcs := &Settings{
+			ClusterName:          "minikube",
+			ClusterServerAddress: "https://192.168.10.100:8443",
+			CertificateAuthority: ca,
+			ClientCertificate:    client,
+			ClientKey:            client,
+			EmbedCerts:           embed,
+			CAOutputPath:         out,
+		}
+		kcs.SetPath(filepath.Join(dir, "config"))
+		if err := Update(kcs); err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "ca pem" {
+			t.Errorf("got CA %q, want %q", got, "ca pem")
+		}
+		info, err := os.Stat(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0644 {
+			t.Errorf("got mode %v, want 0644", info.Mode().Perm())
+		}
+	}
+}
+
+func TestBuildConfig(t *testing.T) {
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+	}
+
+	cfg, err := BuildConfig(kcs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if len(cfg.Contexts) != 1 || cfg.CurrentContext != "minikube" {
+		t.Errorf("Expected only the minikube context, got %v", cfg.Contexts)
+	}
+
+	fn := tempFile(t, kubeConfigWithoutHTTPS)
+	defer os.Remove(fn)
+	kcs.SetPath(fn)
+	cfg, err = BuildConfig(kcs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if _, ok := cfg.Contexts["la-croix"]; !ok {
+		t.Errorf("Expected existing contexts to be kept, got %v", cfg.Contexts)
+	}
+	if _, ok := cfg.Clusters["minikube"].Extensions[clusterExtensionKey]; !ok {
+		t.Errorf("Expected cluster extension to be set")
+	}
+
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(kubeConfigWithoutHTTPS) {
+		t.Errorf("Expected %s to be untouched", fn)
+	}
+
+	// the same file, current-context and server as Update
+	t.Setenv(constants.MinikubeKeepContextEnv, "true")
+	t.Setenv(constants.MinikubeServerOverrideEnv, "https://127.0.0.1:51234")
+	blocker := tempFile(t, nil)
+	kcs.SetPath(strings.Join([]string{filepath.Join(blocker, "config"), fn}, string(filepath.ListSeparator)))
+	cfg, err = BuildConfig(kcs)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if _, ok := cfg.Contexts["la-croix"]; !ok {
+		t.Errorf("Expected the contexts of %s to be kept, got %v", fn, cfg.Contexts)
+	}
+	if cfg.CurrentContext != "la-croix" {
+		t.Errorf("Expected current context la-croix to be kept, got %q", cfg.CurrentContext)
+	}
+	if got := cfg.Clusters["minikube"].Server; got != "https://127.0.0.1:51234" {
+		t.Errorf("got server %q, want the override", got)
+	}
+}
+
+func TestGetSettings(t *testing.T) {
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(ca, []byte("ca"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, embed := range []bool{false, true} {
+		fn := filepath.Join(dir, fmt.Sprintf("config-%t", embed))
+		want := &Settings{
+			ClusterName:          "minikube",
+			Namespace:            "kube-system",
+			ClusterServerAddress: "https://192.168.10.100:8443",
+			CertificateAuthority: ca,
+			ClientCertificate:    ca,
+			ClientKey:            ca,
+			EmbedCerts:           embed,
+		}
+		want.SetPath(fn)
+		if err := Update(want); err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+
+		got, err := GetSettings(fn, "minikube")
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if got.ClusterServerAddress != want.ClusterServerAddress || got.Namespace != want.Namespace || got.EmbedCerts != embed {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+		if embed && (got.CertificateAuthority != "" || got.ClientCertificate != "") {
+			t.Errorf("Expected no certificate paths for embedded certs, got %+v", got)
+		}
+		if !embed && (got.CertificateAuthority != ca || got.ClientCertificate != ca || got.ClientKey != ca) {
+			t.Errorf("got certificate paths %+v, want %s", got, ca)
+		}
+		if got.filePath() != fn {
+			t.Errorf("got path %q, want %q", got.filePath(), fn)
+		}
+
+		if embed {
+			continue
+		}
+		// referenced certificates round-trip into the same entries
+		cfg, err := BuildConfig(got)
+		if err != nil {
+			t.Fatalf("Got unexpected error rebuilding the config: %v", err)
+		}
+		if cfg.Clusters["minikube"].Server != want.ClusterServerAddress || cfg.Contexts["minikube"].Namespace != want.Namespace {
+			t.Errorf("rebuilt config does not match: %+v", cfg)
+		}
+	}
+
+	insecure := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		InsecureSkipTLS:      true,
+	}
+	insecure.SetPath(filepath.Join(dir, "config-insecure"))
+	if err := Update(insecure); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	got, err := GetSettings(insecure.filePath(), "minikube")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if !got.InsecureSkipTLS {
+		t.Errorf("Expected InsecureSkipTLS to be read back, got %+v", got)
+	}
+	cfg, err := BuildConfig(got)
+	if err != nil {
+		t.Fatalf("Got unexpected error rebuilding the config: %v", err)
+	}
+	if !cfg.Clusters["minikube"].InsecureSkipTLSVerify {
+		t.Errorf("rebuilt cluster does not skip TLS verification: %+v", cfg.Clusters["minikube"])
+	}
+
+	fn := tempFile(t, kubeConfigWithoutHTTPS)
+	defer os.Remove(fn)
+	if _, err := GetSettings(fn, "minikube"); err == nil {
+		t.Errorf("Expected error for missing cluster but got none")
+	}
+}
+
+func TestResolveWritePath(t *testing.T) {
+	dir := t.TempDir()
+	// a regular file can't hold other files, which makes paths below it unwritable even for root
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	readOnly := filepath.Join(blocker, "config")
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, kubeConfigWithoutHTTPS, 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing", "config")
+	list := func(paths ...string) string { return strings.Join(paths, string(filepath.ListSeparator)) }
+
+	var tests = []struct {
+		description string
+		paths       string
+		expected    string
+		err         bool
+	}{
+		{
+			description: "first writable",
+			paths:       list(missing, existing),
+			expected:    missing,
+		},
+		{
+			description: "only a later file exists",
+			paths:       list(readOnly, existing),
+			expected:    existing,
+		},
+		{
+			description: "empty entries",
+			paths:       list("", readOnly, "", existing),
+			expected:    existing,
+		},
+		{
+			description: "none writable",
+			paths:       list(readOnly, filepath.Join(blocker, "other")),
+			err:         true,
+		},
+		{
+			description: "empty",
+			paths:       list("", ""),
+			err:         true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := resolveWritePath(test.paths)
+			if test.err {
+				if err == nil {
+					t.Errorf("Expected error but got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			if got != test.expected {
+				t.Errorf("got %q, want %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestUpdateMultiplePaths(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	work := filepath.Join(dir, "work")
+	if err := os.WriteFile(work, kubeConfigWithoutHTTPS, 0600); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "other")
+	if err := os.WriteFile(other, kubeConfigLocalhost, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+	}
+	kcs.SetPath(strings.Join([]string{filepath.Join(blocker, "config"), work, other}, string(filepath.ListSeparator)))
+	if err := Update(kcs); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	cfg, err := readOrNew(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Contexts["minikube"]; !ok {
+		t.Errorf("Expected context to be written to %s", work)
+	}
+	if _, ok := cfg.Contexts["la-croix"]; !ok {
+		t.Errorf("Expected existing entries of %s to be kept", work)
+	}
+	data, err := os.ReadFile(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(kubeConfigLocalhost) {
+		t.Errorf("Expected %s to be untouched", other)
+	}
+}
+
+func TestWritablePathFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	work := filepath.Join(dir, "work")
+	if err := os.WriteFile(work, kubeConfigWithoutHTTPS, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(constants.KubeconfigEnvVar, strings.Join([]string{filepath.Join(blocker, "config"), work}, string(filepath.ListSeparator)))
+
+	if got := WritablePathFromEnv(); got != work {
+		t.Fatalf("got %q, want %q", got, work)
+	}
+
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+	}
+	kcs.SetPath(PathListFromEnv())
+	if err := Update(kcs); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	// what minikube status checks
+	if err := VerifyEndpoint(WritablePathFromEnv(), "minikube", "https://192.168.10.100:8443"); err != nil {
+		t.Errorf("Got unexpected error verifying the endpoint: %v", err)
+	}
+	// what minikube delete runs
+	if err := DeleteContext("minikube"); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	cfg, err := readOrNew(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Contexts["minikube"]; ok {
+		t.Errorf("Expected the context to be deleted from %s", work)
+	}
+	if _, ok := cfg.Contexts["la-croix"]; !ok {
+		t.Errorf("Expected other entries of %s to be kept", work)
+	}
+}
+
+func TestUpdateFallbackPath(t *testing.T) {
+	dir := t.TempDir()
+	// a regular file can't hold other files, which makes paths below it unwritable even for root
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	readOnly := filepath.Join(blocker, ".kube", "config")
+	fallback := filepath.Join(dir, "fallback", "config")
+
+	var tests = []struct {
+		description string
+		path        string
+		fallback    string
+		written     string
+		err         bool
+	}{
+		{
+			description: "primary writable",
+			path:        filepath.Join(dir, "primary", "config"),
+			fallback:    fallback,
+			written:     filepath.Join(dir, "primary", "config"),
+		},
+		{
+			description: "primary read-only",
+			path:        readOnly,
+			fallback:    fallback,
+			written:     fallback,
+		},
+		{
+			description: "both read-only",
+			path:        readOnly,
+			fallback:    filepath.Join(blocker, "fallback"),
+			err:         true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			kcs := &Settings{
+				ClusterName:          "minikube",
+				ClusterServerAddress: "https://192.168.10.100:8443",
+				CertificateAuthority: "/home/apiserver.crt",
+				FallbackPath:         test.fallback,
+			}
+			kcs.SetPath(test.path)
+			err := Update(kcs)
+			if test.err {
+				if err == nil || !strings.Contains(err.Error(), test.path) || !strings.Contains(err.Error(), test.fallback) {
+					t.Errorf("Expected error naming both paths but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			cfg, err := readOrNew(test.written)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := cfg.Contexts["minikube"]; !ok {
+				t.Errorf("Expected context to be written to %s", test.written)
+			}
+		})
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	var tests = []struct {
+		name string
+		err  bool
+	}{
+		{name: "minikube"},
+		{name: "minikube-m02"},
+		{name: "dev.example.com"},
+		{name: "admin@cluster:8443"},
+		{name: "", err: true},
+		{name: "with space", err: true},
+		{name: "with/slash", err: true},
+		{name: "ünïcödé", err: true},
+		{name: "-leading-dash", err: true},
+		{name: strings.Repeat("a", 254), err: true},
+	}
+	for _, test := range tests {
+		err := ValidateName(test.name)
+		if err != nil && !test.err {
+			t.Errorf("ValidateName(%q) got unexpected error: %v", test.name, err)
+		}
+		if err == nil && test.err {
+			t.Errorf("ValidateName(%q) expected error but got none", test.name)
+		}
+	}
+
+	kcs := &Settings{
+		ClusterName:          "with space",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+	}
+	if err := PopulateFromSettings(kcs, api.NewConfig()); err == nil {
+		t.Errorf("Expected PopulateFromSettings to reject an invalid name")
+	}
+	kcs = &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		EnvLabel:             "pro d",
+	}
+	if err := PopulateFromSettings(kcs, api.NewConfig()); err == nil {
+		t.Errorf("Expected PopulateFromSettings to reject an invalid context name")
+	}
+}
+
+func TestKeepContextEnv(t *testing.T) {
+	var tests = []struct {
+		description string
+		env         string
+		keepContext bool
+		keep        bool
+	}{
+		{
+			description: "env unset",
+		},
+		{
+			description: "env true",
+			env:         "true",
+			keep:        true,
+		},
+		{
+			description: "env false",
+			env:         "false",
+		},
+		{
+			description: "env invalid",
+			env:         "maybe",
+		},
+		{
+			description: "field set, env false",
+			env:         "false",
+			keepContext: true,
+			keep:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			t.Setenv(constants.MinikubeKeepContextEnv, test.env)
+			fn := filepath.Join(t.TempDir(), "config")
+			existing := api.NewConfig()
+			existing.CurrentContext = "la-croix"
+			if err := writeToFile(existing, fn); err != nil {
+				t.Fatal(err)
+			}
+			kcs := &Settings{
+				ClusterName:          "minikube",
+				ClusterServerAddress: "https://192.168.10.100:8443",
+				CertificateAuthority: "/home/apiserver.crt",
+				KeepContext:          test.keepContext,
+			}
+			kcs.SetPath(fn)
+			if err := Update(kcs); err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			cfg, err := readOrNew(fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.keep && cfg.CurrentContext != "la-croix" {
+				t.Errorf("Context was changed even though it should have been kept")
+			}
+			if !test.keep && cfg.CurrentContext != "minikube" {
+				t.Errorf("Context was not switched")
+			}
+			if got := kcs.KeepsContext(); got != test.keep {
+				t.Errorf("KeepsContext() = %t, want %t", got, test.keep)
+			}
+		})
+	}
+}
+
+func TestKeepContextEnvGuest(t *testing.T) {
+	t.Setenv(constants.MinikubeKeepContextEnv, "true")
+	cfg := api.NewConfig()
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://localhost:8443",
+		CertificateAuthority: "/var/lib/minikube/certs/ca.crt",
+	}
+	if err := PopulateFromSettings(kcs, cfg); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if cfg.CurrentContext != "minikube" {
+		t.Errorf("PopulateFromSettings honored %s, got current context %q", constants.MinikubeKeepContextEnv, cfg.CurrentContext)
+	}
+}
+
+func TestPreserveNamespace(t *testing.T) {
+	var tests = []struct {
+		description string
+		existing    bool
+		preserve    bool
+		settings    string
+		namespace   string
+	}{
+		{
+			description: "new context",
+			preserve:    true,
+			settings:    "from-settings",
+			namespace:   "from-settings",
+		},
+		{
+			description: "existing context without preserve",
+			existing:    true,
+			settings:    "from-settings",
+			namespace:   "from-settings",
+		},
+		{
+			description: "existing context with preserve",
+			existing:    true,
+			preserve:    true,
+			settings:    "from-settings",
+			namespace:   "from-kubeconfig",
+		},
+		{
+			description: "existing context without namespace in settings",
+			existing:    true,
+			namespace:   "from-kubeconfig",
+		},
+		{
+			description: "new context without namespace in settings",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			cfg := api.NewConfig()
+			if test.existing {
+				context := api.NewContext()
+				context.Cluster = "minikube"
+				context.AuthInfo = "minikube"
+				context.Namespace = "from-kubeconfig"
+				cfg.Contexts["minikube"] = context
+			}
+			kcs := &Settings{
+				ClusterName:          "minikube",
+				Namespace:            test.settings,
+				ClusterServerAddress: "https://192.168.10.100:8443",
+				CertificateAuthority: "/home/apiserver.crt",
+				PreserveNamespace:    test.preserve,
+			}
+			if err := PopulateFromSettings(kcs, cfg); err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			if got := cfg.Contexts["minikube"].Namespace; got != test.namespace {
+				t.Errorf("got namespace %q, want %q", got, test.namespace)
+			}
+		})
+	}
+}
+
+func TestTokenFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{tokenFile, filepath.Join(t.TempDir(), "missing")} {
+		cfg := api.NewConfig()
+		kcs := &Settings{
+			ClusterName:          "minikube",
+			ClusterServerAddress: "https://192.168.10.100:8443",
+			CertificateAuthority: "/home/apiserver.crt",
+			TokenFile:            fn,
+		}
+		if err := PopulateFromSettings(kcs, cfg); err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		if got := cfg.AuthInfos["minikube"].TokenFile; got != fn {
+			t.Errorf("got token file %q, want %q", got, fn)
+		}
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	var tests = []struct {
+		description string
+		proxyURL    string
+		err         bool
+	}{
+		{
+			description: "unset",
+		},
+		{
+			description: "http",
+			proxyURL:    "http://proxy.corp:3128",
+		},
+		{
+			description: "socks5",
+			proxyURL:    "socks5://127.0.0.1:1080",
+		},
+		{
+			description: "unsupported scheme",
+			proxyURL:    "ftp://proxy.corp:21",
+			err:         true,
+		},
+		{
+			description: "no scheme",
+			proxyURL:    "proxy.corp:3128",
+			err:         true,
+		},
+		{
+			description: "not a URL",
+			proxyURL:    "http://[::1",
+			err:         true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			kcs := &Settings{
+				ClusterName:          "minikube",
+				ClusterServerAddress: "https://192.168.10.100:8443",
+				CertificateAuthority: "/home/apiserver.crt",
+				ProxyURL:             test.proxyURL,
+			}
+			kcs.SetPath(filepath.Join(t.TempDir(), "config"))
+			err := Update(kcs)
+			if test.err {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			data, err := os.ReadFile(kcs.filePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.proxyURL == "" && strings.Contains(string(data), "proxy-url") {
+				t.Errorf("Expected no proxy-url to be written:\n%s", data)
+			}
+			if test.proxyURL != "" && !strings.Contains(string(data), "proxy-url: "+test.proxyURL) {
+				t.Errorf("Expected proxy-url %s to be written:\n%s", test.proxyURL, data)
+			}
+		})
+	}
+}
+
+func TestServerOverrideEnv(t *testing.T) {
+	var tests = []struct {
+		description string
+		override    string
+		server      string
+		original    string
+		err         bool
+	}{
+		{
+			description: "unset",
+			server:      "https://192.168.10.100:8443",
+		},
+		{
+			description: "tunnel",
+			override:    "https://127.0.0.1:51234",
+			server:      "https://127.0.0.1:51234",
+			original:    "https://192.168.10.100:8443",
+		},
+		{
+			description: "no scheme",
+			override:    "127.0.0.1:51234",
+			err:         true,
+		},
+		{
+			description: "not a URL",
+			override:    "https://[::1",
+			err:         true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			t.Setenv(constants.MinikubeServerOverrideEnv, test.override)
+			kcs := &Settings{
+				ClusterName:          "minikube",
+				ClusterServerAddress: "https://192.168.10.100:8443",
+				CertificateAuthority: "/home/apiserver.crt",
+			}
+			kcs.SetPath(filepath.Join(t.TempDir(), "config"))
+			err := Update(kcs)
+			if test.err {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+
+			// the kubeconfig of the guest is built by PopulateFromSettings and must keep the real server
+			guest := api.NewConfig()
+			if err := PopulateFromSettings(kcs, guest); err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			if got := guest.Clusters["minikube"].Server; got != kcs.ClusterServerAddress {
+				t.Errorf("PopulateFromSettings wrote server %q, want %q", got, kcs.ClusterServerAddress)
+			}
+
+			cfg, err := readOrNew(kcs.filePath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			cluster := cfg.Clusters["minikube"]
+			if cluster.Server != test.server {
+				t.Errorf("got server %q, want %q", cluster.Server, test.server)
+			}
+			ext, err := decodeExtension(cluster.Extensions, clusterExtensionKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			original := ""
+			if ext != nil {
+				original = ext.OriginalServer
+			}
+			if original != test.original {
+				t.Errorf("got original server %q, want %q", original, test.original)
+			}
+		})
+	}
+}
+
+func TestServerOverrideEndpoint(t *testing.T) {
+	t.Setenv(constants.MinikubeServerOverrideEnv, "https://127.0.0.1:51234")
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+	}
+	kcs.SetPath(filepath.Join(t.TempDir(), "config"))
+	if err := Update(kcs); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	fn := kcs.filePath()
+
+	if err := VerifyEndpoint(fn, "minikube", "https://192.168.10.100:8443"); err != nil {
+		t.Errorf("Expected the original server to verify but got %v", err)
+	}
+	// GetSettings round-trips into the same entries, rather than taking the tunnel for the real server
+	got, err := GetSettings(fn, "minikube")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if got.ClusterServerAddress != kcs.ClusterServerAddress {
+		t.Errorf("got server %q, want %q", got.ClusterServerAddress, kcs.ClusterServerAddress)
+	}
+	if err := Update(got); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	cfg, err := readOrNew(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster := cfg.Clusters["minikube"]; cluster.Server != "https://127.0.0.1:51234" || realServer(cluster) != kcs.ClusterServerAddress {
+		t.Errorf("got server %q for %q, want the override for %q", cluster.Server, realServer(cluster), kcs.ClusterServerAddress)
+	}
+
+	updated, err := UpdateEndpoint("minikube", "192.168.10.100", 8443, fn, nil)
+	if err != nil || updated {
+		t.Errorf("Expected no update for the original server but got %t, %v", updated, err)
+	}
+
+	updated, err = UpdateEndpoint("minikube", "192.168.10.101", 8443, fn, nil)
+	if err != nil || !updated {
+		t.Fatalf("Expected an update for a new server but got %t, %v", updated, err)
+	}
+	cfg, err = readOrNew(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := cfg.Clusters["minikube"]
+	if cluster.Server != "https://127.0.0.1:51234" {
+		t.Errorf("UpdateEndpoint dropped the override, got server %q", cluster.Server)
+	}
+	if got := realServer(cluster); got != "https://192.168.10.101:8443" {
+		t.Errorf("got original server %q, want https://192.168.10.101:8443", got)
+	}
+}
+
+func TestTokenAndExecConfig(t *testing.T) {
+	exec := &api.ExecConfig{Command: "auth-proxy-login", APIVersion: "client.authentication.k8s.io/v1"}
+	var tests = []struct {
+		description string
+		kcs         *Settings
+		token       string
+		exec        bool
+		err         bool
+	}{
+		{
+			description: "token",
+			kcs:         &Settings{Token: "s3cr3t"},
+			token:       "s3cr3t",
+		},
+		{
+			description: "exec",
+			kcs:         &Settings{ExecConfig: exec},
+			exec:        true,
+		},
+		{
+			description: "token and certs",
+			kcs:         &Settings{Token: "s3cr3t", ClientCertificate: "/home/client.crt", ClientKey: "/home/client.key"},
+			err:         true,
+		},
+		{
+			description: "exec and certs",
+			kcs:         &Settings{ExecConfig: exec, ClientCertificate: "/home/client.crt", ClientKey: "/home/client.key"},
+			err:         true,
+		},
+		{
+			description: "token and exec",
+			kcs:         &Settings{Token: "s3cr3t", ExecConfig: exec},
+			err:         true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			cfg := api.NewConfig()
+			test.kcs.ClusterName = "minikube"
+			test.kcs.ClusterServerAddress = "https://192.168.10.100:8443"
+			test.kcs.CertificateAuthority = "/home/apiserver.crt"
+			err := PopulateFromSettings(test.kcs, cfg)
+			if test.err {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			user := cfg.AuthInfos["minikube"]
+			if user.Token != test.token {
+				t.Errorf("got token %q, want %q", user.Token, test.token)
+			}
+			if (user.Exec != nil) != test.exec || (test.exec && user.Exec.Command != exec.Command) {
+				t.Errorf("got exec %+v, want exec %t", user.Exec, test.exec)
+			}
+			if user.ClientCertificate != "" || user.ClientKey != "" {
+				t.Errorf("Expected no client certificates, got %q and %q", user.ClientCertificate, user.ClientKey)
+			}
+		})
+	}
+}
+
+func TestInsecureSkipTLS(t *testing.T) {
+	cfg := api.NewConfig()
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/missing.crt",
+		InsecureSkipTLS:      true,
+	}
+	if err := PopulateFromSettings(kcs, cfg); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	cluster := cfg.Clusters["minikube"]
+	if !cluster.InsecureSkipTLSVerify || cluster.CertificateAuthority != "" || len(cluster.CertificateAuthorityData) != 0 {
+		t.Errorf("Expected an insecure cluster without CA, got %+v", cluster)
+	}
+
+	kcs.EmbedCerts = true
+	if err := PopulateFromSettings(kcs, api.NewConfig()); err == nil {
+		t.Errorf("Expected error combining InsecureSkipTLS with EmbedCerts but got none")
+	}
+}
+
+func TestEnvLabel(t *testing.T) {
+	cfg := api.NewConfig()
+	kcs := &Settings{
+		ClusterName:          "minikube",
+		ClusterServerAddress: "https://192.168.10.100:8443",
+		CertificateAuthority: "/home/apiserver.crt",
+		EnvLabel:             "prod",
+		ExtensionContext:     NewExtension(),
+	}
+	if err := PopulateFromSettings(kcs, cfg); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	if cfg.CurrentContext != "minikube-prod" {
+		t.Errorf("got current context %q, want minikube-prod", cfg.CurrentContext)
+	}
+	context, ok := cfg.Contexts["minikube-prod"]
+	if !ok {
+		t.Fatalf("Expected context minikube-prod to be written, got %v", cfg.Contexts)
+	}
+	if context.Cluster != "minikube" || context.AuthInfo != "minikube" {
+		t.Errorf("Expected context to reference the minikube cluster and user, got %+v", context)
+	}
+	ext, err := decodeExtension(context.Extensions, contextExtensionKey)
+	if err != nil || ext == nil || ext.Env != "prod" {
+		t.Errorf("Expected env to be recorded in the extension, got %+v, %v", ext, err)
+	}
+	if kcs.ExtensionContext.Env != "" {
+		t.Errorf("Settings extension was modified")
+	}
+}
+
+func TestMinimal(t *testing.T) {
+	newSettings := func() *Settings {
+		return &Settings{
+			ClusterName:          "minikube",
+			Namespace:            "default",
+			ClusterServerAddress: "https://192.168.10.100:8443",
+			CertificateAuthority: "/home/apiserver.crt",
+			ExtensionCluster:     NewExtension(),
+			ExtensionContext:     NewExtension(),
+			Minimal:              true,
+		}
+	}
+
+	cfg := api.NewConfig()
+	if err := PopulateFromSettings(newSettings(), cfg); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if len(cfg.Clusters["minikube"].Extensions) != 0 || len(cfg.Contexts["minikube"].Extensions) != 0 {
+		t.Errorf("Expected extensions to be omitted")
+	}
+	if cfg.Contexts["minikube"].Namespace != "" {
+		t.Errorf("Expected default namespace to be omitted, got %q", cfg.Contexts["minikube"].Namespace)
+	}
+
+	// the context exists now, so it is written in full
+	if err := PopulateFromSettings(newSettings(), cfg); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if len(cfg.Clusters["minikube"].Extensions) != 1 || len(cfg.Contexts["minikube"].Extensions) != 1 {
+		t.Errorf("Expected extensions to be written for an existing context")
+	}
+	if cfg.Contexts["minikube"].Namespace != "default" {
+		t.Errorf("Expected namespace to be written for an existing context, got %q", cfg.Contexts["minikube"].Namespace)
+	}
+}
+
 func TestVerifyEndpoint(t *testing.T) {
 
 	var tests = []struct {
 		description string
-		hostname    string
-		port        int
+		expected    string
 		existing    []byte
 		err         bool
-		status      bool
+		notFound    bool
 	}{
 		{
-			description: "empty hostname",
-			hostname:    "",
-			port:        8443,
-			existing:    kubeConfigWithoutHTTPS,
+			description: "empty URL",
+			expected:    "",
+			existing:    kubeConfig192,
 			err:         true,
 		},
 		{
 			description: "no minikube cluster",
-			hostname:    "192.168.10.100",
-			port:        8443,
+			expected:    "https://192.168.10.100:8443",
 			existing:    kubeConfigWithoutHTTPS,
 			err:         true,
+			notFound:    true,
 		},
 		{
-			description: "exactly matching hostname/port",
-			hostname:    "192.168.10.100",
-			port:        8443,
+			description: "exactly matching URL",
+			expected:    "https://192.168.10.100:8443",
+			existing:    kubeConfig192,
+		},
+		{
+			description: "trailing slash and upper case scheme",
+			expected:    "HTTPS://192.168.10.100:8443/",
+			existing:    kubeConfig192,
+		},
+		{
+			description: "no scheme",
+			expected:    "192.168.10.100:8443",
 			existing:    kubeConfig192,
-			status:      true,
 		},
 		{
 			description: "different hostnames",
-			hostname:    "192.168.10.100",
-			port:        8443,
+			expected:    "https://192.168.10.100:8443",
 			existing:    kubeConfigLocalhost,
 			err:         true,
 		},
 		{
-			description: "different hostname",
-			hostname:    "",
-			port:        8443,
+			description: "different ports",
+			expected:    "https://127.0.0.1:84430",
 			existing:    kubeConfigLocalhost,
 			err:         true,
 		},
 		{
-			description: "different ports",
-			hostname:    "127.0.0.1",
-			port:        84430,
+			description: "different scheme",
+			expected:    "http://127.0.0.1:8443",
 			existing:    kubeConfigLocalhost,
 			err:         true,
 		},
+		{
+			description: "path prefix",
+			expected:    "https://192.168.10.100:8443/k8s/clusters/minikube/",
+			existing:    kubeConfigPathPrefix,
+		},
 	}
 
 	for _, test := range tests {
@@ -384,13 +1503,16 @@ func TestVerifyEndpoint(t *testing.T) {
 			t.Parallel()
 			configFilename := tempFile(t, test.existing)
 			defer os.Remove(configFilename)
-			err := VerifyEndpoint("minikube", test.hostname, test.port, configFilename)
+			err := VerifyEndpoint(configFilename, "minikube", test.expected)
 			if err != nil && !test.err {
 				t.Errorf("Got unexpected error: %v", err)
 			}
 			if err == nil && test.err {
 				t.Errorf("Expected error but got none: %v", err)
 			}
+			if notFound := errors.Is(err, ErrClusterNotFound); notFound != test.notFound {
+				t.Errorf("got ErrClusterNotFound %t, want %t: %v", notFound, test.notFound, err)
+			}
 		})
 
 	}
@@ -533,6 +1655,24 @@ func TestEmptyConfig(t *testing.T) {
 	}
 }
 
+func TestMaxConfigBytes(t *testing.T) {
+	defer func(max int64) { MaxConfigBytes = max }(MaxConfigBytes)
+	MaxConfigBytes = int64(len(kubeConfig192))
+
+	configFilename := tempFile(t, kubeConfig192)
+	defer os.Remove(configFilename)
+	if _, err := readOrNew(configFilename); err != nil {
+		t.Errorf("Got unexpected error for a file at the limit: %v", err)
+	}
+
+	oversized := tempFile(t, append(append([]byte{}, kubeConfig192...), '\n'))
+	defer os.Remove(oversized)
+	_, err := readOrNew(oversized)
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Expected size limit error but got %v", err)
+	}
+}
+
 func TestNewConfig(t *testing.T) {
 	dir := t.TempDir()
 
@@ -556,6 +1696,314 @@ func TestNewConfig(t *testing.T) {
 	}
 }
 
+func TestWriteToFiles(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first")
+	second := filepath.Join(dir, "second")
+	if err := os.WriteFile(first, kubeConfigWithoutHTTPS, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := api.NewConfig()
+	minikubeConfig(cfg)
+
+	// the parent of the second target is a regular file, so staging it fails
+	blocked := filepath.Join(first, "config")
+	if err := writeToFiles(context.Background(), map[string]runtime.Object{second: cfg, blocked: cfg}); err == nil {
+		t.Fatalf("Expected error but got none")
+	}
+	if _, err := os.Stat(second); !os.IsNotExist(err) {
+		t.Errorf("Expected %s not to be written, got %v", second, err)
+	}
+	data, err := os.ReadFile(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(kubeConfigWithoutHTTPS) {
+		t.Errorf("Expected %s to be untouched, got:\n%s", first, data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected staged files to be removed, got %v", entries)
+	}
+
+	if err := writeToFiles(context.Background(), map[string]runtime.Object{first: cfg, second: cfg}); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	for _, fn := range []string{first, second} {
+		actual, err := readOrNew(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !configEquals(actual, cfg) {
+			t.Errorf("%s did not match the written config", fn)
+		}
+	}
+}
+
+var kubeConfigDuplicateClusters = []byte(`
+apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority: /home/la-croix/.minikube/ca.crt
+    extensions:
+    - extension:
+        provider: minikube.sigs.k8s.io
+      name: cluster_info
+    server: https://192.168.10.100:8443
+  name: a
+- cluster:
+    certificate-authority: /home/la-croix/.minikube/ca.crt
+    extensions:
+    - extension:
+        provider: minikube.sigs.k8s.io
+      name: cluster_info
+    server: https://192.168.10.100:8443
+  name: b
+- cluster:
+    certificate-authority: /home/la-croix/.minikube/ca.crt
+    extensions:
+    - extension:
+        provider: minikube.sigs.k8s.io
+      name: cluster_info
+    server: https://192.168.10.101:8443
+  name: c
+- cluster:
+    certificate-authority: /home/la-croix/.minikube/ca.crt
+    server: https://192.168.10.100:8443
+  name: d
+- cluster:
+    certificate-authority: /home/la-croix/.minikube/ca.crt
+    server: https://192.168.10.100:8443
+  name: e
+contexts:
+- context:
+    cluster: b
+    user: minikube
+  name: b
+- context:
+    cluster: e
+    user: minikube
+  name: e
+current-context: b
+kind: Config
+preferences: {}
+users:
+- name: minikube
+  user:
+    client-certificate: /home/la-croix/apiserver.crt
+    client-key: /home/la-croix/apiserver.key
+`)
+
+func TestDedupClusters(t *testing.T) {
+	configFilename := tempFile(t, kubeConfigDuplicateClusters)
+	defer os.Remove(configFilename)
+
+	n, err := DedupClusters(configFilename)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 cluster removed but got %d", n)
+	}
+
+	cfg, err := readOrNew(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "c", "d", "e"} {
+		if _, ok := cfg.Clusters[name]; !ok {
+			t.Errorf("Expected cluster %q to be kept", name)
+		}
+	}
+	if _, ok := cfg.Clusters["b"]; ok {
+		t.Errorf("Expected duplicate cluster b to be removed")
+	}
+	if got := cfg.Contexts["b"].Cluster; got != "a" {
+		t.Errorf("Expected context b to be repointed to a, got %q", got)
+	}
+	if got := cfg.Contexts["e"].Cluster; got != "e" {
+		t.Errorf("Expected non-minikube context e to be untouched, got %q", got)
+	}
+}
+
+func TestVerifyAfterWrite(t *testing.T) {
+	defer func(verify bool) { VerifyAfterWrite = verify }(VerifyAfterWrite)
+	VerifyAfterWrite = true
+
+	cfg := api.NewConfig()
+	minikubeConfig(cfg)
+	fn := filepath.Join(t.TempDir(), "config")
+	if err := writeToFile(cfg, fn); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	data, err := encode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyWrite(fn, data); err != nil {
+		t.Errorf("Got unexpected error: %v", err)
+	}
+
+	// simulate a truncated write
+	if err := os.WriteFile(fn, data[:len(data)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyWrite(fn, data); err == nil {
+		t.Errorf("Expected error for truncated file but got none")
+	}
+}
+
+func TestEncodeEmptyConfig(t *testing.T) {
+	cfg := api.NewConfig()
+	cfg.Clusters = nil
+	cfg.AuthInfos = nil
+	cfg.Contexts = nil
+
+	data, err := encode(cfg)
+	if err != nil {
+		t.Fatalf("could not encode config: %v", err)
+	}
+	if strings.Contains(string(data), "null") {
+		t.Errorf("expected no null values, got:\n%s", data)
+	}
+
+	decoded, err := decode(data)
+	if err != nil {
+		t.Fatalf("could not decode config: %v", err)
+	}
+	if !configEquals(decoded, api.NewConfig()) {
+		t.Errorf("expected an empty config, got %+v", decoded)
+	}
+}
+
+func TestSortContexts(t *testing.T) {
+	defer func(sortContexts func([]string)) { SortContexts = sortContexts }(SortContexts)
+
+	cfg := api.NewConfig()
+	for _, name := range []string{"a", "b", "c"} {
+		cfg.Contexts[name] = api.NewContext()
+	}
+
+	contextOrder := func() string {
+		data, err := encode(cfg)
+		if err != nil {
+			t.Fatalf("could not encode config: %v", err)
+		}
+		order := ""
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "  name: ") {
+				order += strings.TrimPrefix(line, "  name: ")
+			}
+		}
+		return order
+	}
+
+	if got := contextOrder(); got != "abc" {
+		t.Errorf("got default order %q, want abc", got)
+	}
+
+	SortContexts = func(names []string) {
+		sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	}
+	if got := contextOrder(); got != "cba" {
+		t.Errorf("got custom order %q, want cba", got)
+	}
+}
+
+func TestWriteToFileMissingParent(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "home", ".kube")
+	filename := filepath.Join(dir, "config")
+
+	expected := api.NewConfig()
+	minikubeConfig(expected)
+	if err := writeToFile(expected, filename); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	actual, err := readOrNew(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !configEquals(actual, expected) {
+		t.Errorf("configs did not match")
+	}
+
+	if goruntime.GOOS != "windows" {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0700 {
+			t.Errorf("got parent mode %v, want 0700", fi.Mode().Perm())
+		}
+	}
+}
+
+func TestWriteToFileBackup(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "config")
+	if err := os.WriteFile(filename, kubeConfig192, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := api.NewConfig()
+	minikubeConfig(expected)
+	if err := writeToFile(expected, filename); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	backup, err := os.ReadFile(filename + ".bak")
+	if err != nil {
+		t.Fatalf("Expected a backup of the previous config: %v", err)
+	}
+	if string(backup) != string(kubeConfig192) {
+		t.Errorf("got backup:\n%s\nwant:\n%s", backup, kubeConfig192)
+	}
+	actual, err := readOrNew(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !configEquals(actual, expected) {
+		t.Errorf("configs did not match")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected only the config and its backup, got %v", entries)
+	}
+}
+
+func TestWriteToFileKeepsMode(t *testing.T) {
+	if goruntime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on windows")
+	}
+	filename := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(filename, kubeConfig192, 0640); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile only applies the mode when creating the file, and is subject to the umask
+	if err := os.Chmod(filename, 0640); err != nil {
+		t.Fatal(err)
+	}
+	cfg := api.NewConfig()
+	minikubeConfig(cfg)
+	if err := writeToFile(cfg, filename); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	after, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Mode().Perm() != 0640 {
+		t.Errorf("got mode %v, want %v", after.Mode().Perm(), os.FileMode(0640))
+	}
+}
+
 func Test_Endpoint(t *testing.T) {
 
 	var tests = []struct {
@@ -598,10 +2046,87 @@ func Test_Endpoint(t *testing.T) {
 	}
 }
 
+var kubeConfigPathPrefix = []byte(`
+apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority: /home/la-croix/apiserver.crt
+    server: https://192.168.10.100:8443/k8s/clusters/minikube
+  name: minikube
+contexts:
+- context:
+    cluster: minikube
+    user: minikube
+  name: minikube
+current-context: minikube
+kind: Config
+preferences: {}
+users:
+- name: minikube
+  user:
+    client-certificate: /home/la-croix/apiserver.crt
+    client-key: /home/la-croix/apiserver.key
+`)
+
+func TestEndpointURL(t *testing.T) {
+	configFilename := tempFile(t, kubeConfigPathPrefix)
+	defer os.Remove(configFilename)
+
+	server, u, err := EndpointURL("minikube", configFilename)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if server != "https://192.168.10.100:8443/k8s/clusters/minikube" {
+		t.Errorf("got server %q", server)
+	}
+	if u.Path != "/k8s/clusters/minikube" {
+		t.Errorf("got path %q, want /k8s/clusters/minikube", u.Path)
+	}
+
+	hostname, port, err := Endpoint("minikube", configFilename)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if hostname != "192.168.10.100" || port != 8443 {
+		t.Errorf("got %s:%d, want 192.168.10.100:8443", hostname, port)
+	}
+
+	if _, _, err := EndpointURL("other", configFilename); err == nil {
+		t.Errorf("Expected error for missing cluster but got none")
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	configFilename := tempFile(t, kubeConfigSharedKeys)
+	defer os.Remove(configFilename)
+
+	for _, redact := range []bool{false, true} {
+		got, err := Snippet("other", configFilename, redact)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+		cfg, err := decode([]byte(got))
+		if err != nil {
+			t.Fatalf("Snippet is not a valid kubeconfig: %v", err)
+		}
+		if len(cfg.Contexts) != 1 || len(cfg.Clusters) != 1 || len(cfg.AuthInfos) != 1 || cfg.CurrentContext != "other" {
+			t.Errorf("Expected only the other context entries, got %+v", cfg)
+		}
+		if key := string(cfg.AuthInfos["other"].ClientKeyData); (key == "key-two") == redact {
+			t.Errorf("got client key %q with redact %t", key, redact)
+		}
+	}
+
+	if _, err := Snippet("missing", configFilename, true); err == nil {
+		t.Errorf("Expected error for missing context but got none")
+	}
+}
+
 // tempFile creates a temporary with the provided bytes as its contents.
 // The caller is responsible for deleting file after use.
 func tempFile(t *testing.T, data []byte) string {
-	tmp, err := os.CreateTemp("", "kubeconfig")
+	// a directory of its own, so that the backup written next to it is cleaned up as well
+	tmp, err := os.CreateTemp(t.TempDir(), "kubeconfig")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,299 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DuplicateCredentials returns the contexts that embed the same client key, keyed by the sha256 fingerprint of the key.
+// Contexts sharing a single user entry are not reported, only distinct users carrying identical key data.
+func DuplicateCredentials(kubeConfigPath string) (map[string][]string, error) {
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	contexts := map[string][]string{}
+	users := map[string]map[string]bool{}
+	for name, ctx := range cfg.Contexts {
+		user, ok := cfg.AuthInfos[ctx.AuthInfo]
+		if !ok || len(user.ClientKeyData) == 0 {
+			continue
+		}
+		fp := fmt.Sprintf("%x", sha256.Sum256(user.ClientKeyData))
+		contexts[fp] = append(contexts[fp], name)
+		if users[fp] == nil {
+			users[fp] = map[string]bool{}
+		}
+		users[fp][ctx.AuthInfo] = true
+	}
+
+	dups := map[string][]string{}
+	for fp, names := range contexts {
+		if len(users[fp]) < 2 {
+			continue
+		}
+		sort.Strings(names)
+		dups[fp] = names
+	}
+	return dups, nil
+}
+
+// CheckPermissions returns an error if the kubeconfig embeds client keys while being readable by group or others.
+// The check is skipped on Windows, where POSIX file modes do not reflect who can read the file.
+func CheckPermissions(kubeConfigPath string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	fi, err := os.Stat(kubeConfigPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "stat %s", kubeConfigPath)
+	}
+	if fi.Mode().Perm()&0077 == 0 {
+		return nil
+	}
+
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return errors.Wrap(err, "read")
+	}
+	for name, user := range cfg.AuthInfos {
+		if len(user.ClientKeyData) != 0 {
+			return errors.Errorf("%s has mode %v but embeds the client key of user %q, run 'chmod 600 %s'", kubeConfigPath, fi.Mode().Perm(), name, kubeConfigPath)
+		}
+	}
+	return nil
+}
+
+// BrokenCertRefs returns the contexts whose referenced (non-embedded) certificate files are missing,
+// mapped to the first missing file path. Relative paths are resolved against the directory of kubeConfigPath.
+func BrokenCertRefs(kubeConfigPath string) (map[string]string, error) {
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	broken := map[string]string{}
+	for name, ctx := range cfg.Contexts {
+		var paths []string
+		if cluster, ok := cfg.Clusters[ctx.Cluster]; ok && len(cluster.CertificateAuthorityData) == 0 {
+			paths = append(paths, cluster.CertificateAuthority)
+		}
+		if user, ok := cfg.AuthInfos[ctx.AuthInfo]; ok {
+			if len(user.ClientCertificateData) == 0 {
+				paths = append(paths, user.ClientCertificate)
+			}
+			if len(user.ClientKeyData) == 0 {
+				paths = append(paths, user.ClientKey)
+			}
+		}
+		for _, p := range paths {
+			if p == "" {
+				continue
+			}
+			p = resolveRef(kubeConfigPath, p)
+			if _, err := os.Stat(p); os.IsNotExist(err) {
+				broken[name] = p
+				break
+			}
+		}
+	}
+	return broken, nil
+}
+
+// CheckSingleCurrentContext returns an error if the files in KUBECONFIG declare no current-context,
+// or declare differing ones, in which case kubectl silently uses the first file's declaration.
+func CheckSingleCurrentContext() error {
+	declared := []string{}
+	contexts := map[string]bool{}
+	for _, fPath := range pathsFromEnv() {
+		cfg, err := readOrNew(fPath)
+		if err != nil {
+			return errors.Wrapf(err, "read %s", fPath)
+		}
+		if cfg.CurrentContext == "" {
+			continue
+		}
+		declared = append(declared, fmt.Sprintf("%q in %s", cfg.CurrentContext, fPath))
+		contexts[cfg.CurrentContext] = true
+	}
+
+	if len(declared) == 0 {
+		return errors.Errorf("no current-context is set in %s", strings.Join(pathsFromEnv(), ", "))
+	}
+	if len(contexts) > 1 {
+		return errors.Errorf("ambiguous current-context, kubectl uses the first of: %s", strings.Join(declared, ", "))
+	}
+	return nil
+}
+
+// ConflictingContexts returns the context names that point at different servers in different files of
+// kubeConfigPath, a list of paths like KUBECONFIG, or of KUBECONFIG itself when kubeConfigPath is empty.
+// kubectl silently uses the first file defining a context, so every "file: server" pair is returned in that order.
+func ConflictingContexts(kubeConfigPath string) (map[string][]string, error) {
+	paths := pathsFromEnv()
+	if kubeConfigPath != "" {
+		paths = filepath.SplitList(kubeConfigPath)
+	}
+
+	definitions := map[string][]string{}
+	servers := map[string]map[string]bool{}
+	for _, fPath := range paths {
+		if fPath == "" {
+			continue
+		}
+		cfg, err := readOrNew(fPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", fPath)
+		}
+		for name, ctx := range cfg.Contexts {
+			cluster, ok := cfg.Clusters[ctx.Cluster]
+			if !ok {
+				continue
+			}
+			if servers[name] == nil {
+				servers[name] = map[string]bool{}
+			}
+			servers[name][cluster.Server] = true
+			definitions[name] = append(definitions[name], fmt.Sprintf("%s: %s", fPath, cluster.Server))
+		}
+	}
+
+	conflicts := map[string][]string{}
+	for name, s := range servers {
+		if len(s) > 1 {
+			conflicts[name] = definitions[name]
+		}
+	}
+	return conflicts, nil
+}
+
+// resolveRef returns the file path p referenced by kubeConfigPath, relative ones are resolved
+// against the directory of kubeConfigPath like kubectl does
+func resolveRef(kubeConfigPath, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(filepath.Dir(kubeConfigPath), p)
+}
+
+// OrphanedCertFiles returns the absolute paths of the files under certDir that no cluster or user in the
+// kubeconfig references. It only reports them, deleting is left to the caller.
+func OrphanedCertFiles(certDir, kubeConfigPath string) ([]string, error) {
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	referenced := map[string]bool{}
+	addRef := func(p string) {
+		if p == "" {
+			return
+		}
+		if abs, err := filepath.Abs(resolveRef(kubeConfigPath, p)); err == nil {
+			referenced[abs] = true
+		}
+	}
+	for _, cluster := range cfg.Clusters {
+		addRef(cluster.CertificateAuthority)
+	}
+	for _, user := range cfg.AuthInfos {
+		addRef(user.ClientCertificate)
+		addRef(user.ClientKey)
+		addRef(user.TokenFile)
+	}
+
+	root, err := filepath.Abs(certDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "abs %s", certDir)
+	}
+	orphans := []string{}
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if !referenced[p] {
+			orphans = append(orphans, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "walking %s", certDir)
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// ValidateContext checks that contextName exists along with the cluster and user it references,
+// and that embedded client key data holds an RSA, EC or PKCS8 private key.
+func ValidateContext(contextName, kubeConfigPath string) error {
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return errors.Wrap(err, "read")
+	}
+	ctx, ok := cfg.Contexts[contextName]
+	if !ok {
+		return errors.Errorf("%q does not appear in %s", contextName, kubeConfigPath)
+	}
+	if _, ok := cfg.Clusters[ctx.Cluster]; !ok {
+		return errors.Errorf("cluster %q of context %q does not appear in %s", ctx.Cluster, contextName, kubeConfigPath)
+	}
+	user, ok := cfg.AuthInfos[ctx.AuthInfo]
+	if !ok {
+		return errors.Errorf("user %q of context %q does not appear in %s", ctx.AuthInfo, contextName, kubeConfigPath)
+	}
+	if len(user.ClientKeyData) > 0 && !isPrivateKey(user.ClientKeyData) {
+		return errors.Errorf("ClientKeyData is not a private key in user %q of context %q", ctx.AuthInfo, contextName)
+	}
+	return nil
+}
+
+// isPrivateKey returns whether data is a PEM encoded RSA, EC or PKCS8 private key
+func isPrivateKey(data []byte) bool {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	if _, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return true
+	}
+	if _, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return true
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,450 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/minikube/pkg/minikube/constants"
+)
+
+// client-key-data values are base64 of "key-one" and "key-two"
+var kubeConfigSharedKeys = []byte(`
+apiVersion: v1
+clusters:
+- cluster:
+    server: https://192.168.10.100:8443
+  name: minikube
+contexts:
+- context:
+    cluster: minikube
+    user: minikube
+  name: minikube
+- context:
+    cluster: minikube
+    user: copy
+  name: copy
+- context:
+    cluster: minikube
+    user: minikube
+  name: minikube-kube-system
+- context:
+    cluster: minikube
+    user: other
+  name: other
+current-context: minikube
+kind: Config
+preferences: {}
+users:
+- name: minikube
+  user:
+    client-key-data: a2V5LW9uZQ==
+- name: copy
+  user:
+    client-key-data: a2V5LW9uZQ==
+- name: other
+  user:
+    client-key-data: a2V5LXR3bw==
+`)
+
+func TestDuplicateCredentials(t *testing.T) {
+	var tests = []struct {
+		description string
+		cfg         []byte
+		expected    []string
+	}{
+		{
+			description: "no embedded keys",
+			cfg:         kubeConfig192,
+		},
+		{
+			description: "shared key across users",
+			cfg:         kubeConfigSharedKeys,
+			expected:    []string{"copy", "minikube", "minikube-kube-system"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			configFilename := tempFile(t, test.cfg)
+			defer os.Remove(configFilename)
+			dups, err := DuplicateCredentials(configFilename)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			if test.expected == nil {
+				if len(dups) != 0 {
+					t.Errorf("expected no duplicates, got %v", dups)
+				}
+				return
+			}
+			if len(dups) != 1 {
+				t.Fatalf("expected one duplicated key, got %v", dups)
+			}
+			for _, names := range dups {
+				if len(names) != len(test.expected) {
+					t.Fatalf("got contexts %v, want %v", names, test.expected)
+				}
+				for i := range names {
+					if names[i] != test.expected[i] {
+						t.Errorf("got contexts %v, want %v", names, test.expected)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on windows")
+	}
+
+	var tests = []struct {
+		description string
+		cfg         []byte
+		mode        os.FileMode
+		err         bool
+	}{
+		{
+			description: "private embedded keys",
+			cfg:         kubeConfigSharedKeys,
+			mode:        0600,
+		},
+		{
+			description: "world readable embedded keys",
+			cfg:         kubeConfigSharedKeys,
+			mode:        0644,
+			err:         true,
+		},
+		{
+			description: "group readable embedded keys",
+			cfg:         kubeConfigSharedKeys,
+			mode:        0640,
+			err:         true,
+		},
+		{
+			description: "world readable referenced keys",
+			cfg:         kubeConfig192,
+			mode:        0644,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			configFilename := tempFile(t, test.cfg)
+			defer os.Remove(configFilename)
+			if err := os.Chmod(configFilename, test.mode); err != nil {
+				t.Fatal(err)
+			}
+			err := CheckPermissions(configFilename)
+			if err != nil && !test.err {
+				t.Errorf("Got unexpected error: %v", err)
+			}
+			if err == nil && test.err {
+				t.Errorf("Expected error but got none")
+			}
+		})
+	}
+}
+
+func TestBrokenCertRefs(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(existing, []byte("cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "client.key")
+
+	cfg := api.NewConfig()
+	cluster := api.NewCluster()
+	cluster.CertificateAuthority = existing
+	cfg.Clusters["minikube"] = cluster
+
+	healthy := api.NewAuthInfo()
+	healthy.ClientCertificate = existing
+	healthy.ClientKeyData = []byte("key")
+	cfg.AuthInfos["healthy"] = healthy
+
+	broken := api.NewAuthInfo()
+	broken.ClientCertificate = existing
+	broken.ClientKey = missing
+	cfg.AuthInfos["broken"] = broken
+
+	relative := api.NewAuthInfo()
+	relative.ClientCertificate = "ca.crt"
+	relative.ClientKeyData = []byte("key")
+	cfg.AuthInfos["relative"] = relative
+
+	for _, name := range []string{"healthy", "broken", "relative"} {
+		context := api.NewContext()
+		context.Cluster = "minikube"
+		context.AuthInfo = name
+		cfg.Contexts[name] = context
+	}
+
+	fn := filepath.Join(dir, "config")
+	if err := writeToFile(cfg, fn); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := BrokenCertRefs(fn)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if len(got) != 1 || got["broken"] != missing {
+		t.Errorf("got %v, want only broken -> %s", got, missing)
+	}
+}
+
+func TestCheckSingleCurrentContext(t *testing.T) {
+	var tests = []struct {
+		description string
+		cfgs        [][]byte
+		err         bool
+	}{
+		{
+			description: "single file",
+			cfgs:        [][]byte{kubeConfig192},
+		},
+		{
+			description: "only one file declares",
+			cfgs:        [][]byte{kubeConfigNoClusters, kubeConfig192},
+		},
+		{
+			description: "files agree",
+			cfgs:        [][]byte{kubeConfig192, kubeConfigLocalhost},
+		},
+		{
+			description: "none declared",
+			cfgs:        [][]byte{kubeConfigNoClusters},
+			err:         true,
+		},
+		{
+			description: "files disagree",
+			cfgs:        [][]byte{kubeConfig192, kubeConfigWithoutHTTPS},
+			err:         true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var paths []string
+			for _, cfg := range test.cfgs {
+				fn := tempFile(t, cfg)
+				defer os.Remove(fn)
+				paths = append(paths, fn)
+			}
+			t.Setenv(constants.KubeconfigEnvVar, strings.Join(paths, string(filepath.ListSeparator)))
+
+			err := CheckSingleCurrentContext()
+			if err != nil && !test.err {
+				t.Errorf("Got unexpected error: %v", err)
+			}
+			if err == nil && test.err {
+				t.Errorf("Expected error but got none")
+			}
+		})
+	}
+}
+
+func TestConflictingContexts(t *testing.T) {
+	fn192 := tempFile(t, kubeConfig192)
+	defer os.Remove(fn192)
+	fnLocalhost := tempFile(t, kubeConfigLocalhost)
+	defer os.Remove(fnLocalhost)
+	fnCopy := tempFile(t, kubeConfig192)
+	defer os.Remove(fnCopy)
+
+	got, err := ConflictingContexts(strings.Join([]string{fn192, fnCopy}, string(filepath.ListSeparator)))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Expected no conflicts for identical files, got %v", got)
+	}
+
+	t.Setenv(constants.KubeconfigEnvVar, strings.Join([]string{fn192, fnLocalhost}, string(filepath.ListSeparator)))
+	got, err = ConflictingContexts("")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	want := map[string][]string{
+		"minikube": {fn192 + ": https://192.168.10.100:8443", fnLocalhost + ": https://127.0.0.1:8443"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestOrphanedCertFiles(t *testing.T) {
+	certDir := t.TempDir()
+	files := []string{"ca.crt", "profiles/p1/client.crt", "profiles/p1/client.key", "profiles/old/client.crt", "profiles/old/client.key"}
+	cfg := api.NewConfig()
+	cluster := api.NewCluster()
+	cfg.Clusters["p1"] = cluster
+	user := api.NewAuthInfo()
+	cfg.AuthInfos["p1"] = user
+	for _, name := range files {
+		fn := filepath.Join(certDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cluster.CertificateAuthority = filepath.Join(certDir, "ca.crt")
+	user.ClientCertificate = filepath.Join(certDir, "profiles", "p1", "client.crt")
+	user.ClientKey = filepath.Join(certDir, "profiles", "p1", "client.key")
+
+	kubeConfig := filepath.Join(t.TempDir(), "config")
+	if err := writeToFile(cfg, kubeConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := OrphanedCertFiles(certDir, kubeConfig)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	want := []string{
+		filepath.Join(certDir, "profiles", "old", "client.crt"),
+		filepath.Join(certDir, "profiles", "old", "client.key"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, name := range files {
+		if _, err := os.Stat(filepath.Join(certDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("Expected %s to be left in place: %v", name, err)
+		}
+	}
+}
+
+func TestOrphanedCertFilesRelative(t *testing.T) {
+	dir := t.TempDir()
+	certDir := filepath.Join(dir, "certs")
+	if err := os.MkdirAll(certDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ca.crt", "old.crt"} {
+		if err := os.WriteFile(filepath.Join(certDir, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := api.NewConfig()
+	cluster := api.NewCluster()
+	cluster.CertificateAuthority = filepath.Join("certs", "ca.crt")
+	cfg.Clusters["minikube"] = cluster
+
+	kubeConfig := filepath.Join(dir, "config")
+	if err := writeToFile(cfg, kubeConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := OrphanedCertFiles(certDir, kubeConfig)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	want := []string{filepath.Join(certDir, "old.crt")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestValidateContext(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		description string
+		keyData     []byte
+		err         string
+	}{
+		{
+			description: "EC key",
+			keyData:     pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}),
+		},
+		{
+			description: "PKCS8 key",
+			keyData:     pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER}),
+		},
+		{
+			description: "PKCS1 RSA key",
+			keyData:     pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		},
+		{
+			description: "certificate in the key slot",
+			keyData:     otherCA(t),
+			err:         "ClientKeyData is not a private key",
+		},
+		{
+			description: "garbage",
+			keyData:     []byte("not a key at all"),
+			err:         "ClientKeyData is not a private key",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			cfg := api.NewConfig()
+			cfg.Clusters["minikube"] = api.NewCluster()
+			user := api.NewAuthInfo()
+			user.ClientKeyData = test.keyData
+			cfg.AuthInfos["minikube"] = user
+			context := api.NewContext()
+			context.Cluster = "minikube"
+			context.AuthInfo = "minikube"
+			cfg.Contexts["minikube"] = context
+
+			fn := filepath.Join(t.TempDir(), "config")
+			if err := writeToFile(cfg, fn); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ValidateContext("minikube", fn)
+			if test.err == "" && err != nil {
+				t.Errorf("Got unexpected error: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("Expected error containing %q but got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"os"
+	"syscall"
+
+	"k8s.io/klog/v2"
+)
+
+// keepOwner gives fPath the owner and group of fi, as far as the current user is allowed to
+func keepOwner(fPath string, fi os.FileInfo) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	if err := os.Lchown(fPath, int(st.Uid), int(st.Gid)); err != nil {
+		klog.Warningf("unable to keep the owner %d:%d of %s: %v", st.Uid, st.Gid, fPath, err)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/clientcmd/api/latest"
+	v1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// PatchContext applies patch to contextName and rewrites only the lines of that context in the file,
+// so every other entry keeps its exact bytes, comments and layout. This avoids noisy diffs in large,
+// version controlled kubeconfigs that Update would otherwise re-marshal as a whole.
+func PatchContext(contextName, kubeConfigPath string, patch func(*api.Context)) error {
+	releaser, err := acquireLock(kubeConfigPath)
+	if err != nil {
+		return err
+	}
+	defer releaser.Release()
+
+	data, err := readLimited(kubeConfigPath)
+	if err != nil {
+		return errors.Wrapf(err, "Error reading file %q", kubeConfigPath)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return errors.Wrap(err, "parsing kubeconfig")
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return errors.Errorf("%s is not a kubeconfig", kubeConfigPath)
+	}
+	root := doc.Content[0]
+
+	lines := strings.SplitAfter(string(data), "\n")
+	item, first, last, err := contextLines(root, contextName, len(lines))
+	if err != nil {
+		return errors.Wrapf(err, "in %s", kubeConfigPath)
+	}
+	// only "- context:" style items, with the dash on the line of the first key, can be spliced
+	dash := item.Column - 3
+	if dash < 0 || len(lines[first]) <= dash || lines[first][dash] != '-' {
+		return errors.Errorf("context %q in %s has an unsupported layout", contextName, kubeConfigPath)
+	}
+
+	raw, err := yaml.Marshal(item)
+	if err != nil {
+		return errors.Wrap(err, "marshal context")
+	}
+	named := v1.NamedContext{}
+	if err := sigsyaml.Unmarshal(raw, &named); err != nil {
+		return errors.Wrapf(err, "decoding context %q", contextName)
+	}
+	c := api.NewContext()
+	if err := latest.Scheme.Convert(&named.Context, c, nil); err != nil {
+		return errors.Wrapf(err, "converting context %q", contextName)
+	}
+
+	patch(c)
+
+	named.Context = v1.Context{}
+	if err := latest.Scheme.Convert(c, &named.Context, nil); err != nil {
+		return errors.Wrapf(err, "converting context %q", contextName)
+	}
+	out, err := sigsyaml.Marshal(named)
+	if err != nil {
+		return errors.Wrapf(err, "encoding context %q", contextName)
+	}
+
+	// indent the patched item exactly like the one it replaces, with the same line endings
+	eol := "\n"
+	if strings.HasSuffix(lines[first], "\r\n") {
+		eol = "\r\n"
+	}
+	rendered := []string{}
+	for i, line := range strings.Split(strings.TrimSuffix(string(out), "\n"), "\n") {
+		indent := strings.Repeat(" ", dash+2)
+		if i == 0 {
+			indent = lines[first][:dash+2]
+		}
+		rendered = append(rendered, indent+line+eol)
+	}
+
+	patched := strings.Join(lines[:first], "") + strings.Join(rendered, "") + strings.Join(lines[last+1:], "")
+	if _, err := decode([]byte(patched)); err != nil {
+		return errors.Wrap(err, "patched kubeconfig does not parse")
+	}
+	if err := writeDataToFiles(context.Background(), map[string][]byte{kubeConfigPath: []byte(patched)}); err != nil {
+		return errors.Wrap(err, "writing kubeconfig")
+	}
+	return nil
+}
+
+// contextLines returns the node of contextName in the contexts of root, along with the 0-based
+// first and last line it spans in a document of total lines.
+func contextLines(root *yaml.Node, contextName string, total int) (*yaml.Node, int, int, error) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "contexts" {
+			continue
+		}
+		// the item can't reach past the next item, or else the next top level key
+		end := total
+		if i+2 < len(root.Content) {
+			end = root.Content[i+2].Line - 1
+		}
+		items := root.Content[i+1].Content
+		for j, item := range items {
+			if item.Kind != yaml.MappingNode || !hasName(item, contextName) {
+				continue
+			}
+			next := end
+			if j+1 < len(items) {
+				next = items[j+1].Line - 1
+			}
+			// comments and blank lines after its own content belong to what follows
+			last := lastLine(item) - 1
+			if last > next-1 {
+				last = next - 1
+			}
+			return item, item.Line - 1, last, nil
+		}
+	}
+	return nil, 0, 0, errors.Errorf("%q does not appear", contextName)
+}
+
+// lastLine returns the 1-based line of the last node within n
+func lastLine(n *yaml.Node) int {
+	line := n.Line
+	for _, c := range n.Content {
+		if l := lastLine(c); l > line {
+			line = l
+		}
+	}
+	return line
+}
+
+// hasName returns whether the mapping node item has a name key with the value name
+func hasName(item *yaml.Node, name string) bool {
+	for k := 0; k+1 < len(item.Content); k += 2 {
+		if item.Content[k].Value == "name" {
+			return item.Content[k+1].Value == name
+		}
+	}
+	return false
+}
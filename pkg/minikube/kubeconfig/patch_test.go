@@ -0,0 +1,169 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// kubeConfigHandEdited keeps comments and a layout that re-marshaling would not preserve
+var kubeConfigHandEdited = []byte(`apiVersion: v1
+# shared team clusters
+clusters:
+- cluster:
+    server: https://10.0.0.1:6443   # staging
+  name: staging
+- cluster:
+    server: https://192.168.10.100:8443
+  name: minikube
+contexts:
+- context:
+    cluster: staging
+    user:    staging
+  name: staging
+- context:
+    cluster: minikube
+    user: minikube
+  name: minikube
+
+# ops is shared with the oncall team
+- context: {cluster: staging, user: staging, namespace: ops}
+  name: ops
+current-context: staging
+kind: Config
+preferences: {}
+users:
+- name: staging
+  user: {token: abc}
+- name: minikube
+  user:
+    client-certificate: /home/la-croix/apiserver.crt
+    client-key: /home/la-croix/apiserver.key
+`)
+
+var kubeConfigHandEditedPatched = []byte(`apiVersion: v1
+# shared team clusters
+clusters:
+- cluster:
+    server: https://10.0.0.1:6443   # staging
+  name: staging
+- cluster:
+    server: https://192.168.10.100:8443
+  name: minikube
+contexts:
+- context:
+    cluster: staging
+    user:    staging
+  name: staging
+- context:
+    cluster: minikube
+    namespace: kube-system
+    user: minikube
+  name: minikube
+
+# ops is shared with the oncall team
+- context: {cluster: staging, user: staging, namespace: ops}
+  name: ops
+current-context: staging
+kind: Config
+preferences: {}
+users:
+- name: staging
+  user: {token: abc}
+- name: minikube
+  user:
+    client-certificate: /home/la-croix/apiserver.crt
+    client-key: /home/la-croix/apiserver.key
+`)
+
+func TestPatchContext(t *testing.T) {
+	configFilename := tempFile(t, kubeConfigHandEdited)
+	defer os.Remove(configFilename)
+
+	err := PatchContext("minikube", configFilename, func(c *api.Context) {
+		c.Namespace = "kube-system"
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(kubeConfigHandEditedPatched) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, kubeConfigHandEditedPatched)
+	}
+
+	err = PatchContext("ops", configFilename, func(c *api.Context) {
+		c.Namespace = "default"
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	cfg, err := readOrNew(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Contexts["ops"].Namespace != "default" || cfg.Contexts["minikube"].Namespace != "kube-system" {
+		t.Errorf("unexpected contexts after patching ops: %+v", cfg.Contexts)
+	}
+
+	if err := PatchContext("missing", configFilename, func(*api.Context) {}); err == nil {
+		t.Errorf("Expected error for missing context but got none")
+	}
+
+	profiles := tempFile(t, kubeConfigProfiles)
+	defer os.Remove(profiles)
+	err = PatchContext("p1", profiles, func(c *api.Context) {
+		c.Namespace = "dev"
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	cfg, err = readOrNew(profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext, err := decodeExtension(cfg.Contexts["p1"].Extensions, contextExtensionKey)
+	if err != nil || ext == nil || ext.Profile != "p1" || cfg.Contexts["p1"].Namespace != "dev" {
+		t.Errorf("Expected namespace dev and extension to survive the patch, got %+v, %+v, %v", cfg.Contexts["p1"], ext, err)
+	}
+}
+
+func TestPatchContextCRLF(t *testing.T) {
+	crlf := func(b []byte) []byte { return []byte(strings.ReplaceAll(string(b), "\n", "\r\n")) }
+	configFilename := tempFile(t, crlf(kubeConfigHandEdited))
+	defer os.Remove(configFilename)
+
+	err := PatchContext("minikube", configFilename, func(c *api.Context) {
+		c.Namespace = "kube-system"
+	})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	got, err := os.ReadFile(configFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := crlf(kubeConfigHandEditedPatched); string(got) != string(want) {
+		t.Errorf("got:\n%q\nwant:\n%q", got, want)
+	}
+}
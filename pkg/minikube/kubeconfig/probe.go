@@ -0,0 +1,143 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+)
+
+// HealthzProbePath is the apiserver endpoint queried by VerifyHealthz, "/livez" works for newer clusters as well
+var HealthzProbePath = "/healthz"
+
+// VerifyHealthz queries the health endpoint of the apiserver behind contextName using the
+// credentials stored in the kubeconfig, and returns an error unless it answers with 200.
+func VerifyHealthz(contextName, kubeConfigPath string, timeout time.Duration) error {
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return errors.Wrap(err, "read")
+	}
+	if _, ok := cfg.Contexts[contextName]; !ok {
+		return errors.Errorf("%q does not appear in %s", contextName, kubeConfigPath)
+	}
+
+	restCfg, err := clientcmd.NewNonInteractiveClientConfig(*cfg, contextName, &clientcmd.ConfigOverrides{}, nil).ClientConfig()
+	if err != nil {
+		return errors.Wrapf(err, "client config for %q", contextName)
+	}
+	restCfg.Timeout = timeout
+	client, err := rest.HTTPClientFor(restCfg)
+	if err != nil {
+		return errors.Wrap(err, "http client")
+	}
+
+	u := strings.TrimSuffix(restCfg.Host, "/") + HealthzProbePath
+	klog.Infof("checking %q health at %s ...", contextName, u)
+	resp, err := client.Get(u)
+	if err != nil {
+		return errors.Wrapf(err, "GET %s", u)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("GET %s returned %s: %s", u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// DetectCADrift compares the certificate chain presented by the apiserver behind contextName with
+// the CA stored in the kubeconfig, and returns true if the chain was not issued by that CA,
+// e.g. because the cluster CA was rotated. Contexts skipping TLS verification are not checked.
+func DetectCADrift(contextName, kubeConfigPath string, timeout time.Duration) (bool, error) {
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return false, errors.Wrap(err, "read")
+	}
+	ctx, ok := cfg.Contexts[contextName]
+	if !ok {
+		return false, errors.Errorf("%q does not appear in %s", contextName, kubeConfigPath)
+	}
+	cluster, ok := cfg.Clusters[ctx.Cluster]
+	if !ok {
+		return false, errors.Errorf("cluster %q of context %q does not appear in %s", ctx.Cluster, contextName, kubeConfigPath)
+	}
+	if cluster.InsecureSkipTLSVerify {
+		klog.Infof("%q skips TLS verification, not checking for CA drift", contextName)
+		return false, nil
+	}
+
+	caData := cluster.CertificateAuthorityData
+	if len(caData) == 0 {
+		caData, err = os.ReadFile(resolveRef(kubeConfigPath, cluster.CertificateAuthority))
+		if err != nil {
+			return false, errors.Wrapf(err, "reading CertificateAuthority %s", cluster.CertificateAuthority)
+		}
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caData) {
+		return false, errors.Errorf("no certificates found in the CA of %q", contextName)
+	}
+
+	u, err := url.Parse(cluster.Server)
+	if err != nil {
+		return false, errors.Wrap(err, "url parse")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	// the chain is verified below against the stored CA rather than during the handshake
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", host, &tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+	if err != nil {
+		return false, errors.Wrapf(err, "dial %s", host)
+	}
+	defer conn.Close()
+
+	chain := conn.ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return false, errors.Errorf("%s presented no certificates", host)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = chain[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) {
+		klog.Infof("%s presents a certificate issued by %q, which is not the stored CA of %q", host, chain[0].Issuer, contextName)
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "verifying certificate of %s", host)
+	}
+	return false, nil
+}
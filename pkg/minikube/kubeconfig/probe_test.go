@@ -0,0 +1,211 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// probeConfig writes a kubeconfig pointing the "minikube" context at srv and returns its path
+func probeConfig(t *testing.T, srv *httptest.Server, insecure bool) string {
+	cfg := api.NewConfig()
+	cluster := api.NewCluster()
+	cluster.Server = srv.URL
+	if insecure {
+		cluster.InsecureSkipTLSVerify = true
+	} else {
+		cluster.CertificateAuthorityData = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	}
+	cfg.Clusters["minikube"] = cluster
+	cfg.AuthInfos["minikube"] = api.NewAuthInfo()
+	context := api.NewContext()
+	context.Cluster = "minikube"
+	context.AuthInfo = "minikube"
+	cfg.Contexts["minikube"] = context
+
+	fn := filepath.Join(t.TempDir(), "config")
+	if err := writeToFile(cfg, fn); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestVerifyHealthz(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	unhealthy := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "[-]etcd failed: reason withheld")
+	}))
+	defer unhealthy.Close()
+
+	var tests = []struct {
+		description string
+		srv         *httptest.Server
+		insecure    bool
+		context     string
+		err         string
+	}{
+		{
+			description: "healthy with CA",
+			srv:         srv,
+			context:     "minikube",
+		},
+		{
+			description: "healthy insecure",
+			srv:         srv,
+			insecure:    true,
+			context:     "minikube",
+		},
+		{
+			description: "unhealthy",
+			srv:         unhealthy,
+			context:     "minikube",
+			err:         "etcd failed",
+		},
+		{
+			description: "missing context",
+			srv:         srv,
+			context:     "other",
+			err:         "does not appear",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			fn := probeConfig(t, test.srv, test.insecure)
+			err := VerifyHealthz(test.context, fn, 5*time.Second)
+			if test.err == "" && err != nil {
+				t.Errorf("Got unexpected error: %v", err)
+			}
+			if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Errorf("Expected error containing %q but got %v", test.err, err)
+			}
+		})
+	}
+}
+
+// otherCA returns a PEM encoded self-signed CA unrelated to the httptest certificate
+func otherCA(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rotatedCA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestDetectCADrift(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var tests = []struct {
+		description string
+		insecure    bool
+		rotated     bool
+		caFile      bool
+		drift       bool
+	}{
+		{
+			description: "matching CA",
+		},
+		{
+			description: "rotated CA",
+			rotated:     true,
+			drift:       true,
+		},
+		{
+			description: "insecure",
+			insecure:    true,
+		},
+		{
+			description: "CA file relative to the kubeconfig",
+			caFile:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			fn := probeConfig(t, srv, test.insecure)
+			if test.rotated {
+				cfg, err := readOrNew(fn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg.Clusters["minikube"].CertificateAuthorityData = otherCA(t)
+				if err := writeToFile(cfg, fn); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.caFile {
+				cfg, err := readOrNew(fn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cluster := cfg.Clusters["minikube"]
+				if err := os.WriteFile(filepath.Join(filepath.Dir(fn), "ca.crt"), cluster.CertificateAuthorityData, 0600); err != nil {
+					t.Fatal(err)
+				}
+				cluster.CertificateAuthorityData = nil
+				cluster.CertificateAuthority = "ca.crt"
+				if err := writeToFile(cfg, fn); err != nil {
+					t.Fatal(err)
+				}
+			}
+			drift, err := DetectCADrift("minikube", fn, 5*time.Second)
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			if drift != test.drift {
+				t.Errorf("got drift %t, want %t", drift, test.drift)
+			}
+		})
+	}
+}
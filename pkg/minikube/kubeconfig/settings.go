@@ -17,15 +17,23 @@ limitations under the License.
 package kubeconfig
 
 import (
+	"context"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/juju/mutex"
 	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/klog/v2"
+	"k8s.io/minikube/pkg/minikube/constants"
 	"k8s.io/minikube/pkg/util/lock"
 )
 
@@ -34,33 +42,90 @@ type Settings struct {
 	// The name of the cluster for this context
 	ClusterName string
 
-	// The name of the namespace for this context
+	// The name of the namespace for this context, if empty the namespace of an existing context is kept
 	Namespace string
 
+	// Profile is the minikube profile recorded in the extensions, ClusterName is recorded by Update if empty
+	Profile string
+
+	// Driver of the minikube profile, recorded in the extensions
+	Driver string
+
 	// ClusterServerAddress is the address of the Kubernetes cluster
 	ClusterServerAddress string
 
+	// KubernetesVersion of the cluster, recorded in the cluster extension
+	KubernetesVersion string
+
 	// ClientCertificate is the path to a client cert file for TLS.
 	ClientCertificate string
 
 	// CertificateAuthority is the path to a cert file for the certificate authority.
 	CertificateAuthority string
 
+	// CAOutputPath is where Update also writes the cluster CA as PEM (0644), for tools that
+	// can't read the embedded certificate-authority-data
+	CAOutputPath string
+
+	// ProxyCA is the path to the CA of a TLS-terminating proxy in front of the cluster.
+	// kubeconfig has a single CA slot, so it is embedded into the cluster extension instead.
+	ProxyCA string
+
+	// ProxyURL is the http, https or socks5 proxy kubectl reaches the cluster through
+	ProxyURL string
+
 	// ClientKey is the path to a client key file for TLS.
 	ClientKey string
 
-	// Should the current context be kept when setting up this one
+	// TokenFile is the path to a bearer token file, re-read by kubectl on every call so it can be rotated.
+	TokenFile string
+
+	// Token is a bearer token, it can't be combined with ExecConfig or client certificates
+	Token string
+
+	// ExecConfig is a credential plugin, it can't be combined with Token or client certificates
+	ExecConfig *api.ExecConfig
+
+	// Should the current context be kept when setting up this one.
+	// Update also keeps it when MINIKUBE_KEEP_CONTEXT is true, which a false KeepContext can't override.
 	KeepContext bool
 
 	// Should the certificate files be embedded instead of referenced by path
 	EmbedCerts bool
 
+	// InsecureSkipTLS writes a cluster without any CA that skips TLS verification, e.g. for throwaway clusters.
+	// It can't be combined with EmbedCerts.
+	InsecureSkipTLS bool
+
+	// Should the namespace of an already existing context be kept instead of Namespace
+	PreserveNamespace bool
+
+	// Minimal writes the smallest valid entries when the context is created: the cluster_info and
+	// context_info extensions are omitted, and so is the namespace when it is "default".
+	// Contexts that already exist are written with all fields.
+	Minimal bool
+
+	// EnvLabel names the environment (e.g. "prod") of this context.
+	// It is appended to the context name as a suffix and recorded in the context extension.
+	EnvLabel string
+
 	// Extension meta data for the cluster
 	ExtensionCluster *Extension
 
 	// Extension meta data for the cluster
 	ExtensionContext *Extension
 
+	// FallbackPath is where Update writes the kube config if the directory of the configured path is not writable,
+	// for instance when ~/.kube is a read-only mount
+	FallbackPath string
+
+	// ProfileDir is a directory where Update also writes the contexts of this profile to <profile>.yaml,
+	// in addition to the main kube config. Both files are replaced together or not at all.
+	ProfileDir string
+
+	// AuditLogPath is a file Update appends a JSON line to after every successful write
+	AuditLogPath string
+
 	// kubeConfigFile is the path where the kube config is stored
 	// Only access this with atomic ops
 	kubeConfigFile atomic.Value
@@ -76,30 +141,121 @@ func (k *Settings) filePath() string {
 	return k.kubeConfigFile.Load().(string)
 }
 
+// contextName returns the name of the context written for these settings
+func (k *Settings) contextName() string {
+	if k.EnvLabel == "" {
+		return k.ClusterName
+	}
+	return k.ClusterName + "-" + k.EnvLabel
+}
+
+// validName describes the characters kubectl accepts in cluster, context and user names
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:@-]*$`)
+
+// ValidateName returns an error if kubectl can't load a cluster, context or user called name
+func ValidateName(name string) error {
+	if name == "" {
+		return errors.New("name must not be empty")
+	}
+	if len(name) > 253 {
+		return errors.Errorf("name %q is longer than 253 characters", name)
+	}
+	if !validName.MatchString(name) {
+		return errors.Errorf("name %q must start with a letter or digit and contain only letters, digits and any of '.', '_', ':', '@', '-'", name)
+	}
+	return nil
+}
+
 // PopulateFromSettings populates an api.Config object with values from *Settings
 func PopulateFromSettings(cfg *Settings, apiCfg *api.Config) error {
+	// the in-VM kubeconfig of the primary node is written with an empty name
+	for _, name := range []string{cfg.ClusterName, cfg.contextName()} {
+		if name == "" {
+			continue
+		}
+		if err := ValidateName(name); err != nil {
+			return errors.Wrap(err, "invalid kubeconfig name")
+		}
+	}
+
+	// Minimal only applies to contexts that are created, existing ones keep their fields
+	_, exists := apiCfg.Contexts[cfg.contextName()]
+	minimal := cfg.Minimal && !exists
+
 	var err error
 	clusterName := cfg.ClusterName
 	cluster := api.NewCluster()
 	cluster.Server = cfg.ClusterServerAddress
-	if cfg.EmbedCerts {
+	switch {
+	case cfg.InsecureSkipTLS && cfg.EmbedCerts:
+		return errors.Errorf("cluster %q can't both skip TLS verification and embed certificates", clusterName)
+	case cfg.InsecureSkipTLS:
+		cluster.InsecureSkipTLSVerify = true
+	case cfg.EmbedCerts:
 		cluster.CertificateAuthorityData, err = os.ReadFile(cfg.CertificateAuthority)
 		if err != nil {
 			return errors.Wrapf(err, "reading CertificateAuthority %s", cfg.CertificateAuthority)
 		}
-	} else {
+	default:
 		cluster.CertificateAuthority = cfg.CertificateAuthority
 	}
 
-	if cfg.ExtensionCluster != nil {
-		cluster.Extensions = map[string]runtime.Object{"cluster_info": cfg.ExtensionCluster.DeepCopy()}
+	if cfg.ProxyURL != "" {
+		u, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return errors.Wrapf(err, "parsing ProxyURL %q", cfg.ProxyURL)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			return errors.Errorf("ProxyURL must be an http, https or socks5 URL, got %q", cfg.ProxyURL)
+		}
+		cluster.ProxyURL = cfg.ProxyURL
+	}
+
+	ext := cfg.ExtensionCluster.DeepCopy()
+	cfg.recordProfile(ext)
+	if cfg.ProxyCA != "" {
+		if ext == nil {
+			ext = NewExtension()
+		}
+		ext.ProxyCAData, err = os.ReadFile(cfg.ProxyCA)
+		if err != nil {
+			return errors.Wrapf(err, "reading ProxyCA %s", cfg.ProxyCA)
+		}
+	}
+	if cfg.KubernetesVersion != "" {
+		if ext == nil {
+			ext = NewExtension()
+		}
+		ext.KubernetesVersion = cfg.KubernetesVersion
+	}
+	if ext != nil && !minimal {
+		cluster.Extensions = map[string]runtime.Object{clusterExtensionKey: ext}
 	}
 	apiCfg.Clusters[clusterName] = cluster
 
 	// user
 	userName := cfg.ClusterName
 	user := api.NewAuthInfo()
-	if cfg.EmbedCerts {
+	// more than one of them would leave kubectl to pick silently
+	sources := []string{}
+	if cfg.Token != "" {
+		sources = append(sources, "a token")
+	}
+	if cfg.ExecConfig != nil {
+		sources = append(sources, "an exec plugin")
+	}
+	if cfg.ClientCertificate != "" || cfg.ClientKey != "" {
+		sources = append(sources, "client certificates")
+	}
+	if len(sources) > 1 {
+		return errors.Errorf("%s are set for user %q, only one can be", strings.Join(sources, " and "), userName)
+	}
+	switch {
+	case cfg.Token != "":
+		user.Token = cfg.Token
+	case cfg.ExecConfig != nil:
+		user.Exec = cfg.ExecConfig.DeepCopy()
+	case cfg.EmbedCerts:
 		user.ClientCertificateData, err = os.ReadFile(cfg.ClientCertificate)
 		if err != nil {
 			return errors.Wrapf(err, "reading ClientCertificate %s", cfg.ClientCertificate)
@@ -108,62 +264,417 @@ func PopulateFromSettings(cfg *Settings, apiCfg *api.Config) error {
 		if err != nil {
 			return errors.Wrapf(err, "reading ClientKey %s", cfg.ClientKey)
 		}
-	} else {
+	default:
 		user.ClientCertificate = cfg.ClientCertificate
 		user.ClientKey = cfg.ClientKey
 	}
+	if cfg.TokenFile != "" {
+		if _, err := os.Stat(cfg.TokenFile); err != nil {
+			klog.Warningf("TokenFile %s is not readable yet: %v", cfg.TokenFile, err)
+		}
+		user.TokenFile = cfg.TokenFile
+	}
 	apiCfg.AuthInfos[userName] = user
 
 	// context
-	contextName := cfg.ClusterName
+	contextName := cfg.contextName()
 	context := api.NewContext()
 	context.Cluster = cfg.ClusterName
 	context.Namespace = cfg.Namespace
+	// an empty Namespace keeps whatever the user switched the existing context to
+	if existing, ok := apiCfg.Contexts[contextName]; ok && (cfg.PreserveNamespace || cfg.Namespace == "") {
+		context.Namespace = existing.Namespace
+	}
+	if minimal && context.Namespace == metav1.NamespaceDefault {
+		context.Namespace = ""
+	}
 	context.AuthInfo = userName
-	if cfg.ExtensionContext != nil {
-		context.Extensions = map[string]runtime.Object{"context_info": cfg.ExtensionContext.DeepCopy()}
+	if cfg.ExtensionContext != nil && !minimal {
+		ext := cfg.ExtensionContext.DeepCopy()
+		ext.Env = cfg.EnvLabel
+		cfg.recordProfile(ext)
+		context.Extensions = map[string]runtime.Object{contextExtensionKey: ext}
 	}
 
 	apiCfg.Contexts[contextName] = context
 
 	// Only set current context to minikube if the user has not used the keepContext flag
 	if !cfg.KeepContext {
-		apiCfg.CurrentContext = cfg.ClusterName
+		apiCfg.CurrentContext = contextName
 	}
 
 	return nil
 }
 
+// KeepsContext reports whether Update keeps the current context, either because KeepContext is set
+// or because MINIKUBE_KEEP_CONTEXT is true.
+func (k *Settings) KeepsContext() bool {
+	return k.KeepContext || keepContextFromEnv()
+}
+
+// keepContextFromEnv reports whether MINIKUBE_KEEP_CONTEXT asks to keep the current context
+func keepContextFromEnv() bool {
+	s := os.Getenv(constants.MinikubeKeepContextEnv)
+	if s == "" {
+		return false
+	}
+	keep, err := strconv.ParseBool(s)
+	if err != nil {
+		klog.ErrorS(err, "failed to parse", "env", constants.MinikubeKeepContextEnv, "value", s)
+		return false
+	}
+	return keep
+}
+
+// serverOverrideFromEnv returns the server address set by MINIKUBE_SERVER_OVERRIDE, if any
+func serverOverrideFromEnv() (string, error) {
+	s := os.Getenv(constants.MinikubeServerOverrideEnv)
+	if s == "" {
+		return "", nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing %s", constants.MinikubeServerOverrideEnv)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", errors.Errorf("%s must be an http or https URL, got %q", constants.MinikubeServerOverrideEnv, s)
+	}
+	return s, nil
+}
+
+// applyServerOverride points clusterName at MINIKUBE_SERVER_OVERRIDE, if set, and records the real server
+// as OriginalServer so that UpdateEndpoint and VerifyEndpoint keep comparing against it
+func applyServerOverride(kcfg *api.Config, clusterName string) error {
+	override, err := serverOverrideFromEnv()
+	if err != nil || override == "" {
+		return err
+	}
+	cluster := kcfg.Clusters[clusterName]
+	ext, err := decodeExtension(cluster.Extensions, clusterExtensionKey)
+	if err != nil {
+		return err
+	}
+	if ext == nil {
+		ext = NewExtension()
+	}
+	klog.Infof("writing server %s instead of %s as set by %s", override, cluster.Server, constants.MinikubeServerOverrideEnv)
+	ext.OriginalServer = cluster.Server
+	cluster.Server = override
+	if cluster.Extensions == nil {
+		cluster.Extensions = map[string]runtime.Object{}
+	}
+	cluster.Extensions[clusterExtensionKey] = ext
+	return nil
+}
+
+// UpdateLockTimeout is how long Update waits for another process holding the kubeconfig lock
+var UpdateLockTimeout = 30 * time.Second
+
 // Update reads config from disk, adds the minikube settings, and writes it back.
 // activeContext is true when minikube is the CurrentContext
 // If no CurrentContext is set, the given name will be used.
 func Update(kcs *Settings) error {
-	spec := lock.PathMutexSpec(filepath.Join(kcs.filePath(), "settings.Update"))
-	klog.Infof("acquiring lock: %+v", spec)
-	releaser, err := mutex.Acquire(spec)
+	ctx, cancel := context.WithTimeout(context.Background(), UpdateLockTimeout)
+	defer cancel()
+	return UpdateWithContext(ctx, kcs)
+}
+
+// UpdateWithContext is Update giving up with an error naming the contended file when ctx is done
+// before the kubeconfig lock could be acquired.
+func UpdateWithContext(ctx context.Context, kcs *Settings) error {
+	fPath, err := kcs.writablePath()
 	if err != nil {
-		return errors.Wrapf(err, "unable to acquire lock for %+v", spec)
+		return err
 	}
-	defer releaser.Release()
 
-	// read existing config or create new if does not exist
-	klog.Infoln("Updating kubeconfig: ", kcs.filePath())
-	kcfg, err := readOrNew(kcs.filePath())
+	releaser, err := acquireLockContext(ctx, fPath)
 	if err != nil {
 		return err
 	}
+	// deferred so the lock is released even if anything below panics
+	defer releaser.Release()
 
-	ext := NewExtension()
-	kcs.ExtensionCluster = ext
-	kcs.ExtensionContext = ext
-	err = PopulateFromSettings(kcs, kcfg)
+	klog.Infoln("Updating kubeconfig: ", fPath)
+	kcfg, err := buildConfig(kcs, fPath)
 	if err != nil {
 		return err
 	}
 
 	// write back to disk
-	if err := writeToFile(kcfg, kcs.filePath()); err != nil {
+	configs := map[string]runtime.Object{fPath: kcfg}
+	if kcs.ProfileDir != "" {
+		configs[filepath.Join(kcs.ProfileDir, kcs.ClusterName+".yaml")] = profileConfig(kcfg, kcs.ClusterName, kcs.contextName())
+	}
+	if err := writeToFiles(ctx, configs); err != nil {
 		return errors.Wrap(err, "writing kubeconfig")
 	}
+	if err := writeCAOutput(kcs, kcfg); err != nil {
+		return err
+	}
+	audit(kcs.AuditLogPath, "update", kcs.contextName(), fPath)
 	return nil
 }
+
+// writeCAOutput writes the CA of the cluster written for kcs to kcs.CAOutputPath, if set
+func writeCAOutput(kcs *Settings, kcfg *api.Config) error {
+	if kcs.CAOutputPath == "" {
+		return nil
+	}
+	if kcs.InsecureSkipTLS {
+		klog.Warningf("not writing %s: cluster %q has no CA", kcs.CAOutputPath, kcs.ClusterName)
+		return nil
+	}
+	caData := kcfg.Clusters[kcs.ClusterName].CertificateAuthorityData
+	if len(caData) == 0 {
+		var err error
+		caData, err = os.ReadFile(kcs.CertificateAuthority)
+		if err != nil {
+			return errors.Wrapf(err, "reading CertificateAuthority %s", kcs.CertificateAuthority)
+		}
+	}
+	if err := os.WriteFile(kcs.CAOutputPath, caData, 0644); err != nil {
+		return errors.Wrapf(err, "writing CA to %s", kcs.CAOutputPath)
+	}
+	// WriteFile only applies the mode when creating the file
+	if err := os.Chmod(kcs.CAOutputPath, 0644); err != nil {
+		return errors.Wrapf(err, "chmod %s", kcs.CAOutputPath)
+	}
+	return nil
+}
+
+// BuildConfig returns the config Update would write for kcs, without locking or writing anything.
+// It reads the same file Update would write to, and if no path was set on kcs, the settings are
+// applied to an empty config.
+func BuildConfig(kcs *Settings) (*api.Config, error) {
+	fPath := ""
+	if _, ok := kcs.kubeConfigFile.Load().(string); ok {
+		var err error
+		if fPath, err = kcs.writablePath(); err != nil {
+			return nil, err
+		}
+	}
+	return buildConfig(kcs, fPath)
+}
+
+// buildConfig reads the config at fPath, or creates an empty one if there is none or fPath is empty,
+// and applies kcs to it. Unlike PopulateFromSettings alone, the environment is honored as well, which
+// only applies to the host kubeconfig and not to the one built for the guest.
+func buildConfig(kcs *Settings, fPath string) (*api.Config, error) {
+	kcfg := api.NewConfig()
+	if fPath != "" {
+		var err error
+		if kcfg, err = readOrNew(fPath); err != nil {
+			return nil, err
+		}
+	}
+
+	current := kcfg.CurrentContext
+	if err := populate(kcs, kcfg); err != nil {
+		return nil, err
+	}
+	if kcs.KeepsContext() {
+		kcfg.CurrentContext = current
+	}
+	if err := applyServerOverride(kcfg, kcs.ClusterName); err != nil {
+		return nil, err
+	}
+	return kcfg, nil
+}
+
+// GetSettings reconstructs the Settings PopulateFromSettings would have been called with for clusterName
+// in kubeConfigFile. EmbedCerts is set if the CA is embedded, in which case no certificate paths are known.
+func GetSettings(kubeConfigFile, clusterName string) (*Settings, error) {
+	kcfg, err := readOrNew(kubeConfigFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+	cluster, ok := kcfg.Clusters[clusterName]
+	if !ok {
+		return nil, errors.Errorf("cluster %q does not appear in %s", clusterName, kubeConfigFile)
+	}
+
+	kcs := &Settings{
+		ClusterName:          clusterName,
+		ClusterServerAddress: realServer(cluster),
+		CertificateAuthority: cluster.CertificateAuthority,
+		EmbedCerts:           len(cluster.CertificateAuthorityData) > 0,
+		InsecureSkipTLS:      cluster.InsecureSkipTLSVerify,
+		ProxyURL:             cluster.ProxyURL,
+	}
+	if ext, err := decodeExtension(cluster.Extensions, clusterExtensionKey); err == nil && ext != nil {
+		// ClusterServerAddress already is the original server, the override is applied anew by Update
+		ext.OriginalServer = ""
+		kcs.ExtensionCluster = ext
+		kcs.KubernetesVersion = ext.KubernetesVersion
+	}
+
+	userName := clusterName
+	if context, ok := kcfg.Contexts[clusterName]; ok {
+		kcs.Namespace = context.Namespace
+		userName = context.AuthInfo
+		if ext, err := decodeExtension(context.Extensions, contextExtensionKey); err == nil && ext != nil {
+			kcs.ExtensionContext = ext
+		}
+	}
+	if user, ok := kcfg.AuthInfos[userName]; ok {
+		kcs.ClientCertificate = user.ClientCertificate
+		kcs.ClientKey = user.ClientKey
+		kcs.TokenFile = user.TokenFile
+		kcs.Token = user.Token
+		kcs.ExecConfig = user.Exec
+	}
+	kcs.SetPath(kubeConfigFile)
+	return kcs, nil
+}
+
+// profileConfig returns a config holding only the contexts of profile, along with their clusters and users.
+// contextName is always included and made the current context.
+func profileConfig(kcfg *api.Config, profile, contextName string) *api.Config {
+	pcfg := api.NewConfig()
+	for name, context := range kcfg.Contexts {
+		if name != contextName {
+			ext, err := decodeExtension(context.Extensions, contextExtensionKey)
+			if err != nil || ext == nil || ext.Profile != profile {
+				continue
+			}
+		}
+		pcfg.Contexts[name] = context
+		if cluster, ok := kcfg.Clusters[context.Cluster]; ok {
+			pcfg.Clusters[context.Cluster] = cluster
+		}
+		if user, ok := kcfg.AuthInfos[context.AuthInfo]; ok {
+			pcfg.AuthInfos[context.AuthInfo] = user
+		}
+	}
+	pcfg.CurrentContext = contextName
+	return pcfg
+}
+
+// recordProfile sets the Profile and Driver of these settings on ext, if set
+func (k *Settings) recordProfile(ext *Extension) {
+	if ext == nil {
+		return
+	}
+	if k.Profile != "" {
+		ext.Profile = k.Profile
+	}
+	if k.Driver != "" {
+		ext.Driver = k.Driver
+	}
+}
+
+// populateSettings is PopulateFromSettings, replaceable in tests
+var populateSettings = PopulateFromSettings
+
+// populate adds the minikube settings along with fresh extensions to kcfg
+func populate(kcs *Settings, kcfg *api.Config) error {
+	ext := NewExtension()
+	ext.Profile = kcs.ClusterName
+	kcs.ExtensionCluster = ext
+	kcs.ExtensionContext = ext
+	return populateSettings(kcs, kcfg)
+}
+
+// writablePath returns the kubeconfig path Update should write to, which is FallbackPath
+// if the directory of the configured path is not writable.
+func (k *Settings) writablePath() (string, error) {
+	fPath := k.filePath()
+	if strings.ContainsRune(fPath, filepath.ListSeparator) {
+		var err error
+		if fPath, err = resolveWritePath(fPath); err != nil {
+			return "", err
+		}
+	}
+	if k.FallbackPath == "" || canWrite(filepath.Dir(fPath)) {
+		return fPath, nil
+	}
+	if !canWrite(filepath.Dir(k.FallbackPath)) {
+		return "", errors.Errorf("neither %s nor fallback %s is writable", fPath, k.FallbackPath)
+	}
+	klog.Warningf("%s is not writable, falling back to %s", fPath, k.FallbackPath)
+	return k.FallbackPath, nil
+}
+
+// resolveWritePath returns the first writable file of paths, a list like KUBECONFIG.
+// Like kubectl, the other files are left alone, so their entries keep taking precedence as before.
+func resolveWritePath(paths string) (string, error) {
+	candidates := []string{}
+	for _, fPath := range filepath.SplitList(paths) {
+		if fPath == "" {
+			continue
+		}
+		candidates = append(candidates, fPath)
+		f, err := os.OpenFile(fPath, os.O_WRONLY, 0)
+		if err == nil {
+			f.Close()
+			return fPath, nil
+		}
+		if os.IsNotExist(err) && canWrite(filepath.Dir(fPath)) {
+			return fPath, nil
+		}
+		klog.Infof("skipping %s, it is not writable: %v", fPath, err)
+	}
+	if len(candidates) == 0 {
+		return "", errors.Errorf("no kubeconfig file in %q", paths)
+	}
+	return "", errors.Errorf("none of %s is writable", strings.Join(candidates, ", "))
+}
+
+// canWrite reports whether files can be created in dir, or in its closest existing parent if dir does not exist yet
+func canWrite(dir string) bool {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+
+	f, err := os.CreateTemp(dir, ".minikube-write-check")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
+
+// acquireLock takes the path mutex guarding read-modify-write cycles of the kubeconfig at kubeConfigPath
+func acquireLock(kubeConfigPath string) (mutex.Releaser, error) {
+	return acquireLockContext(context.Background(), kubeConfigPath)
+}
+
+// acquireLockContext is acquireLock giving up once ctx is done
+func acquireLockContext(ctx context.Context, kubeConfigPath string) (mutex.Releaser, error) {
+	return acquireContext(ctx, lock.PathMutexSpec(filepath.Join(kubeConfigPath, "settings.Update")), kubeConfigPath)
+}
+
+// acquireContext acquires spec guarding fPath, giving up with an error naming fPath once ctx is done
+func acquireContext(ctx context.Context, spec mutex.Spec, fPath string) (mutex.Releaser, error) {
+	timedOut := func(err error) error {
+		return errors.Wrapf(err, "timed out waiting for the lock on %s (%s), another process is updating it", fPath, spec.Name)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, timedOut(err)
+	}
+	spec.Cancel = ctx.Done()
+	if deadline, ok := ctx.Deadline(); ok {
+		spec.Timeout = time.Until(deadline)
+		// the deadline may pass right after the check above, and a zero Timeout would wait forever
+		if spec.Timeout <= 0 {
+			return nil, timedOut(context.DeadlineExceeded)
+		}
+	}
+	klog.Infof("acquiring lock: %+v", spec)
+	releaser, err := mutex.Acquire(spec)
+	if err == mutex.ErrTimeout || err == mutex.ErrCancelled {
+		return nil, timedOut(err)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to acquire lock for %+v", spec)
+	}
+	return releaser, nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver/v4"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+)
+
+// contextStatus summarizes a single context for StatusJSON
+type contextStatus struct {
+	Name              string     `json:"name"`
+	Server            string     `json:"server"`
+	Namespace         string     `json:"namespace,omitempty"`
+	CertExpiry        *time.Time `json:"cert-expiry,omitempty"`
+	KubernetesVersion string     `json:"kubernetes-version,omitempty"`
+	Minikube          bool       `json:"minikube"`
+}
+
+// status is the document returned by StatusJSON
+type status struct {
+	Path           string          `json:"path"`
+	CurrentContext string          `json:"current-context"`
+	Contexts       []contextStatus `json:"contexts"`
+	Problems       []string        `json:"problems"`
+}
+
+// StatusJSON returns a JSON summary of the kubeconfig: every context with its server, namespace,
+// client certificate expiry and whether minikube manages it, along with the problems detected,
+// such as missing certificate files, expired certificates or version skew between minikube clusters.
+// If kubeConfigPath is empty, the path from KUBECONFIG is used.
+func StatusJSON(kubeConfigPath string) ([]byte, error) {
+	if kubeConfigPath == "" {
+		kubeConfigPath = PathFromEnv()
+	}
+	cfg, err := readOrNew(kubeConfigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	st := status{
+		Path:           kubeConfigPath,
+		CurrentContext: cfg.CurrentContext,
+		Contexts:       []contextStatus{},
+		Problems:       []string{},
+	}
+	if cfg.CurrentContext != "" {
+		if _, ok := cfg.Contexts[cfg.CurrentContext]; !ok {
+			st.Problems = append(st.Problems, fmt.Sprintf("current-context %q does not exist", cfg.CurrentContext))
+		}
+	}
+
+	names := []string{}
+	for name := range cfg.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	versions := map[string][]string{}
+	for _, name := range names {
+		context := cfg.Contexts[name]
+		cs := contextStatus{Name: name, Namespace: context.Namespace}
+		if ext, err := decodeExtension(context.Extensions, contextExtensionKey); err == nil {
+			cs.Minikube = ext.managed()
+		}
+		if cluster, ok := cfg.Clusters[context.Cluster]; ok {
+			cs.Server = cluster.Server
+			if ext, err := decodeExtension(cluster.Extensions, clusterExtensionKey); err == nil && ext != nil {
+				cs.KubernetesVersion = ext.KubernetesVersion
+			}
+		} else {
+			st.Problems = append(st.Problems, fmt.Sprintf("context %q references missing cluster %q", name, context.Cluster))
+		}
+		if user, ok := cfg.AuthInfos[context.AuthInfo]; ok {
+			expiry, err := certExpiry(kubeConfigPath, user)
+			if err != nil {
+				klog.Warningf("unable to read client certificate of %q: %v", name, err)
+			}
+			if expiry != nil {
+				cs.CertExpiry = expiry
+				if expiry.Before(time.Now()) {
+					st.Problems = append(st.Problems, fmt.Sprintf("client certificate of context %q expired at %s", name, expiry.Format(time.RFC3339)))
+				}
+			}
+		}
+		if cs.Minikube && cs.KubernetesVersion != "" {
+			versions[cs.KubernetesVersion] = append(versions[cs.KubernetesVersion], name)
+		}
+		st.Contexts = append(st.Contexts, cs)
+	}
+
+	broken, err := BrokenCertRefs(kubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if p, ok := broken[name]; ok {
+			st.Problems = append(st.Problems, fmt.Sprintf("context %q references missing file %s", name, p))
+		}
+	}
+	if skew := versionSkew(versions); skew != "" {
+		st.Problems = append(st.Problems, skew)
+	}
+
+	return json.MarshalIndent(st, "", "  ")
+}
+
+// certExpiry returns when the client certificate of user expires, or nil if it has none.
+// A relative certificate path is resolved against the directory of kubeConfigPath, like kubectl does.
+func certExpiry(kubeConfigPath string, user *api.AuthInfo) (*time.Time, error) {
+	data := user.ClientCertificateData
+	if len(data) == 0 {
+		if user.ClientCertificate == "" {
+			return nil, nil
+		}
+		var err error
+		data, err = os.ReadFile(resolveRef(kubeConfigPath, user.ClientCertificate))
+		if err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &cert.NotAfter, nil
+}
+
+// versionSkew describes the minikube contexts whose Kubernetes minor versions are more than one apart,
+// the skew kubectl supports, or returns "" if there is none
+func versionSkew(versions map[string][]string) string {
+	var lowest, highest *semver.Version
+	var low, high string
+	for v := range versions {
+		sv, err := semver.ParseTolerant(v)
+		if err != nil {
+			continue
+		}
+		if lowest == nil || sv.LT(*lowest) {
+			lowest, low = &sv, v
+		}
+		if highest == nil || sv.GT(*highest) {
+			highest, high = &sv, v
+		}
+	}
+	if lowest == nil || (highest.Major == lowest.Major && highest.Minor-lowest.Minor <= 1) {
+		return ""
+	}
+	return fmt.Sprintf("version skew between %s (%s) and %s (%s)", strings.Join(versions[low], ", "), low, strings.Join(versions[high], ", "), high)
+}
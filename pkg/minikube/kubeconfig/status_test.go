@@ -0,0 +1,111 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kubeconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate expiring at notAfter to fn
+func writeCert(t *testing.T, fn string, notAfter time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "minikube-user"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatusJSON(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "config")
+	valid := filepath.Join(dir, "valid.crt")
+	writeCert(t, valid, time.Now().Add(time.Hour).Truncate(time.Second))
+	expired := filepath.Join(dir, "expired.crt")
+	writeCert(t, expired, time.Now().Add(-time.Hour).Truncate(time.Second))
+
+	for _, kcs := range []*Settings{
+		// relative to the kubeconfig directory
+		{ClusterName: "new", KubernetesVersion: "v1.28.3", ClientCertificate: "valid.crt", ClientKey: valid},
+		{ClusterName: "old", KubernetesVersion: "v1.26.0", ClientCertificate: expired, ClientKey: filepath.Join(dir, "missing.key")},
+	} {
+		kcs.ClusterServerAddress = "https://192.168.10.100:8443"
+		kcs.CertificateAuthority = valid
+		kcs.SetPath(fn)
+		if err := Update(kcs); err != nil {
+			t.Fatalf("Got unexpected error: %v", err)
+		}
+	}
+
+	data, err := StatusJSON(fn)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+	var got status
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("StatusJSON returned invalid JSON: %v", err)
+	}
+
+	if got.Path != fn || got.CurrentContext != "old" {
+		t.Errorf("got path %q and current context %q, want %q and old", got.Path, got.CurrentContext, fn)
+	}
+	if len(got.Contexts) != 2 {
+		t.Fatalf("Expected 2 contexts, got %+v", got.Contexts)
+	}
+	for _, cs := range got.Contexts {
+		if !cs.Minikube || cs.Server != "https://192.168.10.100:8443" || cs.CertExpiry == nil {
+			t.Errorf("unexpected summary %+v", cs)
+		}
+	}
+
+	problems := strings.Join(got.Problems, "\n")
+	for _, want := range []string{
+		`client certificate of context "old" expired`,
+		`context "old" references missing file`,
+		"version skew between old (v1.26.0) and new (v1.28.3)",
+	} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("Expected problems to contain %q, got:\n%s", want, problems)
+		}
+	}
+	if strings.Contains(problems, `"new"`) {
+		t.Errorf("Expected no problems for context new, got:\n%s", problems)
+	}
+}
@@ -519,10 +519,18 @@ func setupKubeconfig(h *host.Host, cc *config.ClusterConfig, n *config.Node, clu
 	if cc.KubernetesConfig.APIServerName != constants.APIServerName {
 		addr = strings.ReplaceAll(addr, n.IP, cc.KubernetesConfig.APIServerName)
 	}
+	// only an explicit --namespace replaces the namespace the user may have switched the context to since
+	namespace := ""
+	if viper.IsSet("namespace") {
+		namespace = cc.KubernetesConfig.Namespace
+	}
 	kcs := &kubeconfig.Settings{
 		ClusterName:          clusterName,
-		Namespace:            cc.KubernetesConfig.Namespace,
+		Namespace:            namespace,
+		Profile:              cc.Name,
+		Driver:               cc.Driver,
 		ClusterServerAddress: addr,
+		KubernetesVersion:    cc.KubernetesConfig.KubernetesVersion,
 		ClientCertificate:    localpath.ClientCert(cc.Name),
 		ClientKey:            localpath.ClientKey(cc.Name),
 		CertificateAuthority: localpath.CACert(),
@@ -530,7 +538,7 @@ func setupKubeconfig(h *host.Host, cc *config.ClusterConfig, n *config.Node, clu
 		EmbedCerts:           cc.EmbedCerts,
 	}
 
-	kcs.SetPath(kubeconfig.PathFromEnv())
+	kcs.SetPath(kubeconfig.PathListFromEnv())
 	return kcs
 }
 
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 The Kubernetes Authors All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine/host"
+	"github.com/spf13/viper"
+	"k8s.io/minikube/pkg/minikube/config"
+	"k8s.io/minikube/pkg/minikube/constants"
+	"k8s.io/minikube/pkg/minikube/kubeconfig"
+	"k8s.io/minikube/pkg/minikube/localpath"
+)
+
+func TestSetupKubeconfigWritesFirstWritableFile(t *testing.T) {
+	t.Setenv(localpath.MinikubeHome, t.TempDir())
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	work := filepath.Join(dir, "work")
+	other := filepath.Join(dir, "other")
+	for _, fn := range []string{work, other} {
+		if err := os.WriteFile(fn, []byte("apiVersion: v1\nkind: Config\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv(constants.KubeconfigEnvVar, strings.Join([]string{filepath.Join(blocker, "config"), work, other}, string(filepath.ListSeparator)))
+
+	cc := &config.ClusterConfig{
+		Name:             "minikube",
+		Driver:           "none",
+		KubernetesConfig: config.KubernetesConfig{APIServerName: constants.APIServerName},
+	}
+	n := &config.Node{IP: "192.168.10.100", Port: 8443}
+	kcs := setupKubeconfig(&host.Host{DriverName: "none"}, cc, n, cc.Name)
+	if err := kubeconfig.Update(kcs); err != nil {
+		t.Fatalf("Got unexpected error: %v", err)
+	}
+
+	if err := kubeconfig.VerifyEndpoint(work, "minikube", "https://192.168.10.100:8443"); err != nil {
+		t.Errorf("Expected the cluster to be written to %s: %v", work, err)
+	}
+	data, err := os.ReadFile(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "minikube") {
+		t.Errorf("Expected %s to be untouched:\n%s", other, data)
+	}
+}
+
+func TestSetupKubeconfigKeepsNamespace(t *testing.T) {
+	t.Setenv(localpath.MinikubeHome, t.TempDir())
+	defer viper.Reset()
+
+	cc := &config.ClusterConfig{
+		Name:   "minikube",
+		Driver: "none",
+		KubernetesConfig: config.KubernetesConfig{
+			APIServerName: constants.APIServerName,
+			Namespace:     "default",
+		},
+	}
+	n := &config.Node{IP: "192.168.10.100", Port: 8443}
+
+	var tests = []struct {
+		description string
+		flag        string
+		namespace   string
+	}{
+		{
+			description: "namespace switched with kubectl",
+			namespace:   "work",
+		},
+		{
+			description: "explicit --namespace",
+			flag:        "ops",
+			namespace:   "ops",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), "config")
+			t.Setenv(constants.KubeconfigEnvVar, fn)
+			viper.Reset()
+			if err := kubeconfig.Update(setupKubeconfig(&host.Host{DriverName: "none"}, cc, n, cc.Name)); err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			if _, err := kubeconfig.SetNamespaceForServer("https://192.168.10.100:8443", "work", fn); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.flag != "" {
+				viper.Set("namespace", test.flag)
+				cc.KubernetesConfig.Namespace = test.flag
+			}
+			if err := kubeconfig.Update(setupKubeconfig(&host.Host{DriverName: "none"}, cc, n, cc.Name)); err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			kcs, err := kubeconfig.GetSettings(fn, "minikube")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kcs.Namespace != test.namespace {
+				t.Errorf("got namespace %q, want %q", kcs.Namespace, test.namespace)
+			}
+		})
+	}
+}